@@ -25,6 +25,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
 	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel/mem"
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
 )
 
 type services []datamodel.GlobalService
@@ -66,17 +67,37 @@ func clustersFromFile(path string) ([]string, []cluster, datamodel.Infrastructur
 	}
 
 	names := make([]string, len(c))
-	cls := make(map[string]string, len(c))
+	cls := make(map[string]datamodel.Cluster, len(c))
+	var errs error
 	for i, cl := range c {
+		if cl.Address == "" && len(cl.Addresses) == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("cluster %q has no address", cl.Name))
+		}
+		if cl.Address != "" {
+			if err := routing.ValidateAddress(cl.Address); err != nil {
+				errs = multierror.Append(errs, errors.Wrapf(err, "invalid address for cluster %q", cl.Name))
+			}
+		}
+		for _, address := range cl.Addresses {
+			if err := routing.ValidateAddress(address.Address); err != nil {
+				errs = multierror.Append(errs, errors.Wrapf(err, "invalid address for cluster %q", cl.Name))
+			}
+		}
+		if _, err := routing.IstioAPIVersion(cl.IstioVersion); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "invalid cluster %q", cl.Name))
+		}
 		names[i] = cl.Name
-		cls[cl.Name] = cl.Address
+		cls[cl.Name] = cl.Cluster
+	}
+	if errs != nil {
+		return []string{}, []cluster{}, nil, errs
 	}
 	sort.Strings(names)
 	return names, c, mem.Infrastructure(cls), nil
 }
 
 func clustersFlagToInfra(clusters []string) ([]string, datamodel.Infrastructure, error) {
-	cls := make(map[string]string, len(clusters))
+	cls := make(map[string]datamodel.Cluster, len(clusters))
 	names := make([]string, 0, len(clusters))
 	var errs error
 	for i, c := range clusters {
@@ -85,7 +106,7 @@ func clustersFlagToInfra(clusters []string) ([]string, datamodel.Infrastructure,
 			errs = multierror.Append(errs, fmt.Errorf("expected `name:address` pairs but got %q", c))
 			continue
 		}
-		cls[parts[0]] = parts[1]
+		cls[parts[0]] = datamodel.Cluster{Name: parts[0], Address: parts[1]}
 		names[i] = parts[0]
 	}
 	sort.Strings(names)
@@ -16,8 +16,13 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"sort"
+	"strings"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
@@ -33,6 +38,16 @@ func configGenCmd() *cobra.Command {
 		//clusters     []string
 		clustersFile string
 		servicesFile string
+		domainSuffix string
+		clusterHosts bool
+		teardown     bool
+		patches      bool
+		egress       bool
+		sidecars     bool
+		dnsConfig    bool
+		strict       bool
+		stubs        bool
+		stubEPs      bool
 	)
 
 	cmd := &cobra.Command{
@@ -42,6 +57,29 @@ func configGenCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var err error
 
+			// the other outputs cover every service, and only the config and the Sidecars can be torn down
+			var modes []string
+			for flag, set := range map[string]bool{
+				"--gateway-patches":        patches,
+				"--egress-gateway-patches": egress,
+				"--sidecars":               sidecars,
+				"--dns-config":             dnsConfig,
+			} {
+				if set {
+					modes = append(modes, flag)
+				}
+			}
+			sort.Strings(modes)
+			if len(modes) > 1 {
+				return fmt.Errorf("only one of %s can be set", strings.Join(modes, ", "))
+			}
+			if len(modes) == 1 && service != "" {
+				return fmt.Errorf("--service can't be used with %s, which prints the output for every service", modes[0])
+			}
+			if len(modes) == 1 && teardown && !sidecars {
+				return fmt.Errorf("--delete can't be used with %s", modes[0])
+			}
+
 			var infra datamodel.Infrastructure
 			var clusters []string
 			//if clustersFile != "" {
@@ -59,38 +97,77 @@ func configGenCmd() *cobra.Command {
 				return errors.Wrapf(err, "could not read services from %q", servicesFile)
 			}
 
-			svcs, cfgs, err := routing.GenerateConfigs(dm, infra, clusters)
-			if err != nil {
-				return errors.Wrap(err, "could not construct config from clusters and services")
+			// imports don't matter to teardown, which deletes the config of every cluster
+			if errs := routing.CheckImports(dm, infra, clusters); len(errs) > 0 && !teardown {
+				if strict {
+					return errors.Wrap(multierror.Append(nil, errs...), "unsatisfied imports")
+				}
+				for _, err := range errs {
+					log.Printf("warning: %v", err)
+				}
+			}
+
+			opts := routing.Options{
+				DomainSuffix:       domainSuffix,
+				ClusterPinnedHosts: clusterHosts,
+				StubServices:       stubs || stubEPs,
+				StubEndpoints:      stubEPs,
 			}
 
 			// TODO: flag for output to file, etc.
 			out := os.Stdout
 
-			for _, svc := range svcs {
-				if service != "" && svc != service {
-					continue
+			if patches {
+				cls, patch, err := routing.GenerateGatewayServicePatches(dm, infra, opts)
+				if err != nil {
+					return errors.Wrap(err, "could not construct ingress gateway patches from clusters and services")
 				}
+				printPerCluster(out, "Ingress gateway Service patch", cls, patch, cluster)
+				return nil
+			}
 
-				fmt.Fprintf(out, "################################################################################\n")
-				fmt.Fprintf(out, "# Configs for Service %q\n", svc)
-				fmt.Fprintf(out, "################################################################################\n")
-				for cl, cfg := range cfgs[svc] {
-					// filter output by --cluster flag
-					if cluster != "" && cl != cluster {
-						continue
-					}
-
-					fmt.Fprintf(out, "####################\n")
-					fmt.Fprintf(out, "# Configs for Cluster %q\n", cl)
-					fmt.Fprintf(out, "####################\n")
-					for _, c := range cfg {
-						fmt.Fprint(out, "---\n")
-						fmt.Fprint(out, string(c.Yaml))
-						fmt.Fprint(out, "\n")
-					}
+			if egress {
+				cls, patch, err := routing.GenerateEgressGatewayServicePatches(dm, infra, clusters)
+				if err != nil {
+					return errors.Wrap(err, "could not construct egress gateway patches from clusters and services")
 				}
+				printPerCluster(out, "Egress gateway Service patch", cls, patch, cluster)
+				return nil
 			}
+
+			if sidecars {
+				cls, sidecar, err := routing.GenerateSidecars(dm, infra, clusters, opts)
+				if err != nil {
+					return errors.Wrap(err, "could not construct sidecars from clusters and services")
+				}
+				title := "Sidecars"
+				if teardown {
+					// kubectl deletes the Sidecars by name, as it does the other config
+					title = "Sidecars to delete"
+				}
+				printPerCluster(out, title, cls, sidecar, cluster)
+				return nil
+			}
+
+			if dnsConfig {
+				cls, zones, err := routing.GenerateDNSConfig(dm, infra, clusters, opts)
+				if err != nil {
+					return errors.Wrap(err, "could not construct DNS config from clusters and services")
+				}
+				printPerCluster(out, "CoreDNS config", cls, zones, cluster)
+				return nil
+			}
+
+			generate := routing.GenerateConfigs
+			if teardown {
+				generate = routing.GenerateTeardownConfigs
+			}
+			svcs, cfgs, err := generate(dm, infra, clusters, opts)
+			if err != nil {
+				return errors.Wrap(err, "could not construct config from clusters and services")
+			}
+
+			routing.PrintConfigs(out, svcs, cfgs, service, cluster)
 			return nil
 		},
 	}
@@ -107,6 +184,49 @@ func configGenCmd() *cobra.Command {
 		`Path to a file with a JSON array of clusters, where a cluster is an object like '{"name": "ClusterName", "address": "dns.address.of.cluster"}'`)
 	cmd.PersistentFlags().StringVar(&servicesFile, "service-file", "./services.json",
 		`Path to a file with a JSON array of GlobalServices, see datamodel.GlobalService for the JSON schema.`)
+	cmd.PersistentFlags().BoolVar(&teardown, "delete", false,
+		"Print the configuration to delete to remove the services, rather than the configuration to apply; with --sidecars, the Sidecars to delete. "+
+			"It names all of the config which could have been generated for the services, whatever the other flags. "+
+			"E.g. `kubectl delete --ignore-not-found -f <(cw gen --delete --service foo --cluster cluster-name) --context cluster-name`")
+	cmd.PersistentFlags().BoolVar(&patches, "gateway-patches", false,
+		"Print, for each cluster hosting a backend, a strategic merge patch for its ingress gateway Service which opens the ports "+
+			"the generated Gateways listen on, rather than the configuration. "+
+			"E.g. `kubectl patch service istio-ingressgateway -n istio-system --context cluster-name -p \"$(cw gen --gateway-patches --cluster cluster-name)\"`")
+	cmd.PersistentFlags().BoolVar(&egress, "egress-gateway-patches", false,
+		"Print, for each cluster with an egress gateway, a strategic merge patch for its egress gateway Service which opens the ports "+
+			"the sidecars send the traffic of the services they call to, rather than the configuration. "+
+			"E.g. `kubectl patch service istio-egressgateway -n istio-system --context cluster-name -p \"$(cw gen --egress-gateway-patches --cluster cluster-name)\"`")
+	cmd.PersistentFlags().BoolVar(&sidecars, "sidecars", false,
+		"Print, for each cluster, a Sidecar for each namespace a service is exported to, which limits the namespace's sidecars "+
+			"to the global hosts it can call, rather than the configuration.")
+	cmd.PersistentFlags().BoolVar(&dnsConfig, "dns-config", false,
+		"Print, for each cluster, a CoreDNS server block for each DNS suffix, whose hosts plugin resolves the global hosts "+
+			"the cluster can call, rather than the configuration. Services without an address resolve to a placeholder in 240.240.0.0/16.")
+	cmd.PersistentFlags().BoolVar(&strict, "strict-imports", false,
+		"Fail if a cluster imports a service which doesn't exist or isn't exported to it, rather than printing a warning.")
+	cmd.PersistentFlags().StringVar(&domainSuffix, "domain-suffix", routing.DefaultDomainSuffix,
+		`DNS suffix appended to each service's DNS prefixes, e.g. "foo" is called as "foo.global". A service's "domain_suffix" takes precedence.`)
+	cmd.PersistentFlags().BoolVar(&stubs, "stub-services", false,
+		`Also generate a Kubernetes Service with no selector for each host of a service whose DNS prefix is a single label, `+
+			`e.g. the Service "foo" in the namespace "global" for "foo.global", so that kube-dns resolves the host.`)
+	cmd.PersistentFlags().BoolVar(&stubEPs, "stub-endpoints", false,
+		"Also generate Endpoints for the stub Services, holding the IP addresses of the ingress gateways of the backend clusters. Implies --stub-services.")
+	cmd.PersistentFlags().BoolVar(&clusterHosts, "cluster-hosts", false,
+		`Also generate hosts which call a service's backend in a single cluster, e.g. "foo.b.global" for the backend of "foo" in cluster "b".`)
 
 	return cmd
 }
+
+// printPerCluster prints the YAML generated for each cluster, only for the filter cluster if set.
+func printPerCluster(out io.Writer, title string, clusters []string, yamls map[string][]byte, filter string) {
+	for _, cl := range clusters {
+		// filter output by --cluster flag
+		if filter != "" && cl != filter {
+			continue
+		}
+		fmt.Fprintf(out, "####################\n")
+		fmt.Fprintf(out, "# %s for Cluster %q\n", title, cl)
+		fmt.Fprintf(out, "####################\n")
+		fmt.Fprint(out, string(yamls[cl]))
+	}
+}
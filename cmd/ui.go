@@ -37,6 +37,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel/mem"
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
 	"github.com/istio-ecosystem/coddiwomple/pkg/ui"
 )
 
@@ -59,6 +60,8 @@ func uiCmd() (serve *cobra.Command) {
 		port int
 		//clusters    []string
 		clustersFile string
+		domainSuffix string
+		clusterHosts bool
 	)
 
 	serve = &cobra.Command{
@@ -82,12 +85,12 @@ func uiCmd() (serve *cobra.Command) {
 				}
 				log.Printf("Watching for %q across all namespaces in cluster %q with resync period %d",
 					resourcePluralName, cluster.Name, resyncPeriod)
-				i := sdk.NewInformerWithHandler(resourcePluralName, allNamespaces, client, resyncPeriod, collector, dm.Handler(cluster.Name))
+				i := sdk.NewInformerWithHandler(resourcePluralName, allNamespaces, client, resyncPeriod, collector, dm.Handler(cluster.Name, cluster.Domain))
 				go i.Run(context.Background())
 			}
 
 			mux := http.NewServeMux()
-			ui.RegisterHandlers(dm, infra, clusterNames, mux)
+			ui.RegisterHandlers(dm, infra, clusterNames, routing.Options{DomainSuffix: domainSuffix, ClusterPinnedHosts: clusterHosts}, mux)
 			address := fmt.Sprintf(":%d", port)
 			log.Printf("starting server on %s", address)
 			return http.ListenAndServe(address, mux)
@@ -103,6 +106,10 @@ func uiCmd() (serve *cobra.Command) {
 
 	serve.PersistentFlags().StringVar(&clustersFile, "cluster-file", "",
 		`Path to a file with a JSON array of clusters, where a cluster is an object like '{"name": "ClusterName", "address": "dns.address.of.cluster", "kubeconfig_path": "/path/to/kubeconfig/for/cluster", "kubeconfig_context": "context_name"}'`)
+	serve.PersistentFlags().StringVar(&domainSuffix, "domain-suffix", routing.DefaultDomainSuffix,
+		`DNS suffix appended to each service's DNS prefixes, e.g. "foo" is called as "foo.global".`)
+	serve.PersistentFlags().BoolVar(&clusterHosts, "cluster-hosts", false,
+		`Also generate hosts which call a service's backend in a single cluster, e.g. "foo.b.global" for the backend of "foo" in cluster "b".`)
 
 	return serve
 }
@@ -30,7 +30,7 @@ import (
 
 type (
 	// infra is an implementation of datamodel.infra which stores the set of clusters in memory.
-	infra map[string]string
+	infra map[string]datamodel.Cluster
 
 	// DataModel is an implementation of datamodel.DataModel which stores the set of services in memory.
 	DataModel struct {
@@ -46,14 +46,39 @@ var (
 	_ datamodel.DataModel      = &DataModel{}
 )
 
-func Infrastructure(clusters map[string]string) datamodel.Infrastructure {
+func Infrastructure(clusters map[string]datamodel.Cluster) datamodel.Infrastructure {
 	return infra(clusters)
 }
 
 func (i infra) GetIngressGatewayAddress(clusterName string) (string, error) {
+	addresses, err := i.GetIngressGatewayAddresses(clusterName)
+	if err != nil {
+		return "", err
+	}
+	return addresses[0].Address, nil
+}
+
+// GetIngressGatewayAddresses returns the cluster's Address, if set, followed by its Addresses.
+func (i infra) GetIngressGatewayAddresses(clusterName string) ([]datamodel.GatewayAddress, error) {
+	v, found := i[clusterName]
+	if !found {
+		return nil, ErrNotFound
+	}
+	addresses := make([]datamodel.GatewayAddress, 0, len(v.Addresses)+1)
+	if v.Address != "" {
+		addresses = append(addresses, datamodel.GatewayAddress{Address: v.Address})
+	}
+	addresses = append(addresses, v.Addresses...)
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("cluster %q has no ingress gateway address", clusterName)
+	}
+	return addresses, nil
+}
+
+func (i infra) GetCluster(clusterName string) (datamodel.Cluster, error) {
 	v, found := i[clusterName]
 	if !found {
-		return "", ErrNotFound
+		return datamodel.Cluster{}, ErrNotFound
 	}
 	return v, nil
 }
@@ -107,16 +132,23 @@ func (d *DataModel) ListGlobalServices() map[string]*datamodel.GlobalService {
 	return out
 }
 
-func (d *DataModel) Handler(cluster string) sdk.Handler {
+// Handler returns a handler for Service events from the named cluster, whose services live under the DNS domain
+// (datamodel.DefaultDomain if empty).
+func (d *DataModel) Handler(cluster, domain string) sdk.Handler {
+	if domain == "" {
+		domain = datamodel.DefaultDomain
+	}
 	return perClusterWatcher{
-		name: cluster,
-		dm:   d,
+		name:   cluster,
+		domain: domain,
+		dm:     d,
 	}
 }
 
 type perClusterWatcher struct {
-	name string // name of the cluster we're watching
-	dm   datamodel.DataModel
+	name   string // name of the cluster we're watching
+	domain string // DNS domain of the services in the cluster
+	dm     datamodel.DataModel
 }
 
 func (p perClusterWatcher) Handle(ctx context.Context, event sdk.Event) error {
@@ -153,7 +185,7 @@ func (p perClusterWatcher) GetOrCreateGlobalService(s *v1.Service) *datamodel.Gl
 				s.Name + "." + s.Namespace,
 			},
 			Ports:        ports,
-			Backends:     map[string]string{p.name: serviceName(s)},
+			Backends:     map[string]string{p.name: serviceName(s, p.domain)},
 			Unregistered: false,
 		}
 
@@ -168,15 +200,15 @@ func (p perClusterWatcher) GetOrCreateGlobalService(s *v1.Service) *datamodel.Gl
 	// service with the same name already exists; merge them. We assume ports and DNS prefixes already match.
 	// TODO: do we need to do more checking to ensure the services really match (e.g. not assume DNS, ports match)?
 	if _, exists := gs.Backends[p.name]; !exists {
-		gs.Backends[p.name] = serviceName(s)
+		gs.Backends[p.name] = serviceName(s, p.domain)
 	}
 	return gs
 }
 
-func serviceName(s *v1.Service) string {
+func serviceName(s *v1.Service, domain string) string {
 	if s.ClusterName != "" {
 		return fmt.Sprintf("%s.%s.%s", s.Name, s.Namespace, s.ClusterName)
 	} else {
-		return fmt.Sprintf("%s.%s.svc.cluster.local", s.Name, s.Namespace)
+		return fmt.Sprintf("%s.%s.%s", s.Name, s.Namespace, domain)
 	}
 }
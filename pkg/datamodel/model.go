@@ -46,6 +46,98 @@ type Port struct {
 	Name string `json:"name"`
 }
 
+// TLS modes supported for traffic between a calling cluster and the ingress gateway of a backend cluster.
+const (
+	// TLSModeSimple terminates TLS at the ingress gateway using the given server certificate.
+	TLSModeSimple = "SIMPLE"
+	// TLSModeMutual terminates TLS at the ingress gateway, and requires callers to present a client certificate.
+	TLSModeMutual = "MUTUAL"
+	// TLSModeIstioMutual is MUTUAL using the certificates Istio provisions for each proxy.
+	TLSModeIstioMutual = "ISTIO_MUTUAL"
+	// TLSModePassthrough forwards TLS connections untouched; the application is responsible for TLS.
+	TLSModePassthrough = "PASSTHROUGH"
+)
+
+// TLS describes how traffic for a service is secured as it crosses from a calling cluster
+// to the ingress gateway of a backend cluster. Certificate fields are paths to files mounted
+// into the proxies.
+type TLS struct {
+	// Mode MUST BE one of SIMPLE|MUTUAL|ISTIO_MUTUAL|PASSTHROUGH.
+	Mode string `json:"mode"`
+
+	// ServerCertificate is the certificate the ingress gateway presents to callers.
+	// Required for SIMPLE and MUTUAL.
+	ServerCertificate string `json:"server_certificate,omitempty"`
+	// PrivateKey is the ingress gateway's private key. Required for SIMPLE and MUTUAL.
+	PrivateKey string `json:"private_key,omitempty"`
+	// CaCertificates is used by the ingress gateway to verify client certificates. Required for MUTUAL.
+	CaCertificates string `json:"ca_certificates,omitempty"`
+
+	// ClientCertificate is the certificate callers present to the ingress gateway. Required for MUTUAL.
+	ClientCertificate string `json:"client_certificate,omitempty"`
+	// ClientPrivateKey is the callers' private key. Required for MUTUAL.
+	ClientPrivateKey string `json:"client_private_key,omitempty"`
+	// ClientCaCertificates is used by callers to verify the ingress gateway's certificate.
+	ClientCaCertificates string `json:"client_ca_certificates,omitempty"`
+
+	// SubjectAltNames, if set, are the identities callers accept from the ingress gateway.
+	SubjectAltNames []string `json:"subject_alt_names,omitempty"`
+}
+
+// TrafficPolicy describes how the HTTP requests to a service are handled as they cross between clusters.
+// Durations are strings such as "1.5s" or "300ms".
+type TrafficPolicy struct {
+	// Timeout of each request, including retries.
+	Timeout string `json:"timeout,omitempty"`
+	// Retries of failed requests.
+	Retries *Retries `json:"retries,omitempty"`
+	// Fault is injected into the requests of callers in other clusters, e.g. for game days.
+	Fault *Fault `json:"fault,omitempty"`
+}
+
+// Retries describes how failed requests are retried.
+type Retries struct {
+	// Attempts is the number of retries of a request.
+	Attempts int32 `json:"attempts"`
+	// PerTryTimeout is the timeout of each attempt.
+	PerTryTimeout string `json:"per_try_timeout,omitempty"`
+	// RetryOn lists the conditions to retry on, e.g. "5xx,connect-failure", as understood by Envoy.
+	RetryOn string `json:"retry_on,omitempty"`
+}
+
+// Fault describes the faults to inject into requests.
+type Fault struct {
+	// Delay delays requests before forwarding them.
+	Delay *FaultDelay `json:"delay,omitempty"`
+	// Abort fails requests without forwarding them.
+	Abort *FaultAbort `json:"abort,omitempty"`
+}
+
+// FaultDelay delays a percentage of the requests.
+type FaultDelay struct {
+	// Duration of the delay.
+	Duration string `json:"duration"`
+	// Percent of the requests to delay, 0-100.
+	Percent int32 `json:"percent"`
+}
+
+// FaultAbort fails a percentage of the requests with an HTTP status.
+type FaultAbort struct {
+	// HTTPStatus returned to the caller.
+	HTTPStatus int32 `json:"http_status"`
+	// Percent of the requests to abort, 0-100.
+	Percent int32 `json:"percent"`
+}
+
+// ExportPolicy describes the clusters which can call a service, either by name or by label. Clusters hosting a
+// backend of the service can always call their local backend.
+type ExportPolicy struct {
+	// Clusters lists the names of the clusters which can call the service.
+	Clusters []string `json:"clusters,omitempty"`
+	// ClusterSelector, if set, selects the clusters which can call the service by their Labels.
+	ClusterSelector map[string]string `json:"cluster_selector,omitempty"`
+}
+
 // GlobalService is a service exposed from a cluster. All traffic will
 // arrive at the ingress gateway of the cluster.
 type GlobalService struct {
@@ -61,27 +153,115 @@ type GlobalService struct {
 	// is the DNS suffix.
 	DNSPrefixes []string `json:"dns_prefixes"`
 
+	// DomainSuffix, if set, overrides the generator's DNS suffix for this service's hosts.
+	DomainSuffix string `json:"domain_suffix,omitempty"`
+
 	// Ports exposed by the service.
 	Ports []Port `json:"ports"`
 
 	// Backend services in different clusters
 	Backends map[string]string `json:"backends"`
 
+	// Weights of the traffic callers send to each backend cluster, keyed by cluster name; they must sum to 100.
+	// Clusters without a weight receive no traffic. When unset, traffic is load balanced across all of the backends,
+	// except that callers in a backend cluster call their local backend.
+	Weights map[string]uint32 `json:"weights,omitempty"`
+
+	// ClusterHeader, if set, is the name of a request header callers can set to the name of a backend cluster, to
+	// send the request to the backend in that cluster, e.g. x-cw-cluster. Applies to the service's HTTP ports;
+	// requests without the header are routed as usual.
+	ClusterHeader string `json:"cluster_header,omitempty"`
+
+	// ExportTo, if set, lists the namespaces of the calling clusters which can call the service; "." is the
+	// namespace the config is generated in, and "*" is every namespace. By default every namespace can. The namespaces
+	// of the gateways carrying the service's traffic are always added.
+	ExportTo []string `json:"export_to,omitempty"`
+
+	// ExportPolicy, if set, limits the clusters which can call the service. By default every cluster can.
+	ExportPolicy *ExportPolicy `json:"export_policy,omitempty"`
+
 	// Address is the VIP assigned to this service
 	Address net.IP `json:"address"`
 
+	// Failover, when set, lets clusters hosting a backend of this service fail over to the backends in
+	// other clusters when their local backend is unhealthy. Requires the Locality of each backend cluster.
+	Failover bool `json:"failover,omitempty"`
+
+	// TLS secures traffic to this service between clusters. When unset traffic crosses in plaintext.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// TrafficPolicy, if set, applies timeouts, retries and fault injection to the service's HTTP ports. They apply
+	// at the callers' sidecars only, so that requests aren't retried at each hop between the clusters.
+	TrafficPolicy *TrafficPolicy `json:"traffic_policy,omitempty"`
+
 	// Unregistered is set by the server to indicate that
 	// the service will be removed in the future after cleaning up
 	// the associated configurations from the respective clusters
 	Unregistered bool `json:"unregistered,omitempty"`
 }
 
+// Defaults for the optional settings of a Cluster.
+const (
+	// DefaultNamespace is the namespace generated config is placed in.
+	DefaultNamespace = "cw"
+	// DefaultDomain is the DNS domain of the Kubernetes services in a cluster.
+	DefaultDomain = "svc.cluster.local"
+)
+
 // Cluster represents a cluster that can host services.
 type Cluster struct {
 	// Name of this cluster
 	Name string `json:"name"`
 	// Address is the DNS address of this cluster
 	Address string `json:"address"`
+	// Addresses of the cluster's ingress gateway, e.g. one per zone, in addition to Address.
+	Addresses []GatewayAddress `json:"addresses,omitempty"`
+	// Labels of the cluster, used to select groups of clusters, e.g. {"env": "prod"}.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Imports, if set, lists the global services the cluster calls, by name or glob, e.g. "reviews" or "payments-*".
+	// The cluster gets config only for the services it imports, along with those it hosts a backend of.
+	Imports []string `json:"imports,omitempty"`
+	// Namespace generated config is placed in. Defaults to DefaultNamespace.
+	Namespace string `json:"namespace,omitempty"`
+	// Domain is the DNS domain of the services in this cluster. Defaults to DefaultDomain.
+	Domain string `json:"domain,omitempty"`
+	// GatewaySelector holds the labels of the ingress gateway pods that accept traffic from
+	// other clusters, e.g. a dedicated east-west gateway. Defaults to istio=ingressgateway.
+	GatewaySelector map[string]string `json:"gateway_selector,omitempty"`
+	// Revision, if set, is the revision (istio.io/rev label) of the Istio install the
+	// ingress gateway belongs to.
+	Revision string `json:"revision,omitempty"`
+	// Locality of the cluster's workloads, in the form region/zone/subzone.
+	Locality string `json:"locality,omitempty"`
+	// GatewayPort, if set, is the single port of the ingress gateway through which all traffic from other clusters
+	// enters the cluster, e.g. 15443. Otherwise traffic enters on each service port.
+	GatewayPort uint32 `json:"gateway_port,omitempty"`
+	// GatewayService is the Kubernetes Service of the ingress gateway, as namespace/name.
+	// Defaults to istio-system/istio-ingressgateway.
+	GatewayService string `json:"gateway_service,omitempty"`
+	// IstioVersion is the release of Istio the cluster runs, e.g. 1.5. It picks the version of the Istio API, and
+	// the fields, of the config generated for the cluster. Defaults to the v1alpha3 API of Istio 1.0, which doesn't
+	// support export_to, retry_on, failover, gateway addresses with a locality or Sidecars.
+	IstioVersion string `json:"istio_version,omitempty"`
+	// EgressGateway, if set, sends all traffic from this cluster to other clusters through an egress gateway.
+	EgressGateway *EgressGateway `json:"egress_gateway,omitempty"`
+}
+
+// GatewayAddress is one of the addresses of a cluster's ingress gateway.
+type GatewayAddress struct {
+	// Address is the DNS name or IP address of the ingress gateway.
+	Address string `json:"address"`
+	// Locality, if set, is the locality of the address, in the form region/zone/subzone.
+	Locality string `json:"locality,omitempty"`
+}
+
+// EgressGateway describes the egress gateway of a cluster.
+type EgressGateway struct {
+	// Selector holds the labels of the egress gateway pods. Defaults to istio=egressgateway.
+	Selector map[string]string `json:"selector,omitempty"`
+	// Host is the name of the egress gateway's Kubernetes Service.
+	// Defaults to istio-egressgateway.istio-system.<cluster domain>.
+	Host string `json:"host,omitempty"`
 }
 
 // Clusters is a list of Cluster
@@ -94,4 +274,12 @@ type Infrastructure interface {
 	// GetIngressGatewayAddress returns the address of the ingress gateway
 	// of a cluster, that is accessible from other clusters.
 	GetIngressGatewayAddress(clusterName string) (string, error)
+
+	// GetIngressGatewayAddresses returns all of the addresses of the ingress
+	// gateway of a cluster that are accessible from other clusters.
+	GetIngressGatewayAddresses(clusterName string) ([]GatewayAddress, error)
+
+	// GetCluster returns the cluster with the given name, including its settings
+	// such as the namespace and DNS domain to use for config generated for it.
+	GetCluster(clusterName string) (Cluster, error)
 }
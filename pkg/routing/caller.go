@@ -0,0 +1,338 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// clusterLabel is the label on each ServiceEntry endpoint naming the cluster behind it.
+const clusterLabel = "cluster"
+
+// buildDestinationRulesForGlobalService generates the DestinationRules used by a cluster calling a service hosted in
+// other clusters. They originate TLS to the ingress gateways of the backend clusters, and define a subset per backend
+// cluster to route to by weight or header. DestinationRules name a single host, so we build one for each of the
+// service's hosts.
+func buildDestinationRulesForGlobalService(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) ([]*IstioConfigDescriptor, error) {
+
+	var subsets []*istioapi.Subset
+	if len(globalService.Weights) > 0 || globalService.ClusterHeader != "" {
+		for _, cluster := range sortedBackends(globalService) {
+			subsets = append(subsets, &istioapi.Subset{
+				Name:   cluster,
+				Labels: map[string]string{clusterLabel: cluster},
+			})
+		}
+	}
+
+	out := make([]*IstioConfigDescriptor, 0, len(globalService.DNSPrefixes))
+	for _, host := range globalHosts(globalService, opts) {
+		policy, err := tlsOriginationPolicy(globalService, host)
+		if err != nil {
+			return nil, err
+		}
+		destinationRule := &istioapi.DestinationRule{
+			Host:          host,
+			Subsets:       subsets,
+			TrafficPolicy: policy,
+		}
+
+		if destinationRule.TrafficPolicy == nil && len(destinationRule.Subsets) == 0 {
+			continue
+		}
+
+		destinationRuleCRD := &istioconfig.Config{
+			ConfigMeta: configMeta(istioconfig.DestinationRule, globalService,
+				fmt.Sprintf("cw-%s-destinationrule", strings.Replace(host, ".", "-", -1)), clusterFor(infrastructure, localCluster)),
+			Spec: destinationRule,
+		}
+
+		destinationRuleYAML, err := protoConfigToYAML(istioconfig.DestinationRule, destinationRuleCRD,
+			exportTo(globalService, clusterFor(infrastructure, localCluster)))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &IstioConfigDescriptor{
+			Name:    destinationRuleCRD.Name,
+			Hosts:   []string{host},
+			Config:  destinationRuleCRD,
+			Yaml:    destinationRuleYAML,
+			Cluster: localCluster,
+		})
+	}
+	return out, nil
+}
+
+// tlsOriginationPolicy returns the policy callers use to originate TLS to the ingress gateways for the host, or nil if
+// they don't originate TLS for any of the service's ports.
+func tlsOriginationPolicy(globalService *datamodel.GlobalService, host string) (*istioapi.TrafficPolicy, error) {
+	if globalService.TLS == nil {
+		return nil, nil
+	}
+	tls, err := clientTLSSettings(globalService.TLS, host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS for service %q: %v", globalService.Name, err)
+	}
+	if tls == nil {
+		return nil, nil
+	}
+
+	policy := &istioapi.TrafficPolicy{}
+	for _, p := range globalService.Ports {
+		if passthrough(globalService, p) {
+			continue
+		}
+		policy.PortLevelSettings = append(policy.PortLevelSettings, &istioapi.TrafficPolicy_PortTrafficPolicy{
+			// matches the port number declared by the ServiceEntry
+			Port: &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: p.BackendPort}},
+			Tls:  tls,
+		})
+	}
+	if len(policy.PortLevelSettings) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// buildVirtualServiceForCallers generates the virtual service used by a cluster calling a service hosted in other
+// clusters. It splits traffic across the backend clusters by weight, steers requests to a backend cluster by header,
+// sends traffic through the cluster's egress gateway if it has one, and applies the service's traffic policy. Returns
+// nil if there's nothing to do, in which case traffic goes straight from the sidecars to the endpoints of the
+// ServiceEntry.
+func buildVirtualServiceForCallers(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) (*IstioConfigDescriptor, error) {
+
+	local := clusterFor(infrastructure, localCluster)
+	if len(globalService.Weights) == 0 && globalService.ClusterHeader == "" && local.EgressGateway == nil &&
+		globalService.TrafficPolicy == nil {
+		return nil, nil
+	}
+
+	route := routeToBackend
+	if len(globalService.Weights) > 0 {
+		var err error
+		if route, err = weightedRoute(globalService); err != nil {
+			return nil, err
+		}
+	}
+
+	return callerVirtualService(globalService, local, globalHosts(globalService, opts),
+		fmt.Sprintf("cw-%s-virtualservice-local", globalService.Name), route, true, true)
+}
+
+// callerVirtualService generates a virtual service named name for a cluster calling the hosts of a service, with the
+// given route to the backend clusters. If steer is set, requests with the service's ClusterHeader go to the subset of
+// the cluster they name. If withFault is set, the faults of the service's traffic policy are injected.
+func callerVirtualService(globalService *datamodel.GlobalService, local datamodel.Cluster, hosts []string, name string,
+	route func(host string, port uint32) []*istioapi.DestinationWeight, steer, withFault bool) (*IstioConfigDescriptor, error) {
+
+	policy, err := parseTrafficPolicy(globalService)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualService := &istioapi.VirtualService{
+		Hosts:    hosts,
+		Gateways: []string{"mesh"},
+	}
+
+	for _, p := range callerPorts(globalService) {
+		if local.EgressGateway == nil {
+			if steer {
+				for _, r := range clusterHeaderRoutes(globalService, p, nil, hosts[0]) {
+					policy.apply(r, withFault)
+					virtualService.Http = append(virtualService.Http, r)
+				}
+			}
+			policy.apply(addRoute(virtualService, globalService, p, nil, route(hosts[0], p.BackendPort)), withFault)
+			continue
+		}
+
+		// Sidecars send traffic to the egress gateway, which sends it on to the backend clusters. The policy applies
+		// to the sidecars' requests, so that they aren't retried at both hops.
+		egressGateway := egressGatewayName(globalService)
+		policy.apply(addRoute(virtualService, globalService, p, []string{"mesh"},
+			routeToBackend(local.EgressGateway.Host, p.BackendPort)), withFault)
+		if steer {
+			virtualService.Http = append(virtualService.Http, clusterHeaderRoutes(globalService, p, []string{egressGateway}, hosts[0])...)
+		}
+		addRoute(virtualService, globalService, p, []string{egressGateway}, route(hosts[0], p.BackendPort))
+	}
+	if local.EgressGateway != nil {
+		virtualService.Gateways = append(virtualService.Gateways, egressGatewayName(globalService))
+	}
+
+	// The backend clusters host the "-remote" virtual service, serving callers in other clusters
+	virtualServiceCRD := &istioconfig.Config{
+		ConfigMeta: configMeta(istioconfig.VirtualService, globalService, name, local),
+		Spec:       virtualService,
+	}
+
+	virtualServiceYAML, err := protoConfigToYAML(istioconfig.VirtualService, virtualServiceCRD, policy.patch(),
+		exportTo(globalService, local))
+	if err != nil {
+		return nil, err
+	}
+
+	return &IstioConfigDescriptor{
+		Name:    virtualServiceCRD.Name,
+		Hosts:   hosts,
+		Config:  virtualServiceCRD,
+		Yaml:    virtualServiceYAML,
+		Cluster: local.Name,
+	}, nil
+}
+
+// addRoute adds a route for traffic to the port of the service arriving from the given gateways, or from any of the
+// virtual service's gateways if nil. Returns the route if it's an HTTP route, nil otherwise.
+func addRoute(virtualService *istioapi.VirtualService, globalService *datamodel.GlobalService, p datamodel.Port,
+	gateways []string, route []*istioapi.DestinationWeight) *istioapi.HTTPRoute {
+
+	protocol := istioconfig.ParseProtocol(p.Protocol)
+	switch {
+	case passthrough(globalService, p):
+		virtualService.Tls = append(virtualService.Tls, &istioapi.TLSRoute{
+			Match: []*istioapi.TLSMatchAttributes{{SniHosts: virtualService.Hosts, Port: p.BackendPort, Gateways: gateways}},
+			Route: route,
+		})
+	case protocol.IsHTTP():
+		httpRoute := &istioapi.HTTPRoute{
+			Match: []*istioapi.HTTPMatchRequest{{Port: p.BackendPort, Gateways: gateways}},
+			Route: route,
+		}
+		virtualService.Http = append(virtualService.Http, httpRoute)
+		return httpRoute
+	case protocol.IsTCP():
+		virtualService.Tcp = append(virtualService.Tcp, &istioapi.TCPRoute{
+			Match: []*istioapi.L4MatchAttributes{{Port: p.BackendPort, Gateways: gateways}},
+			Route: route,
+		})
+	}
+	return nil
+}
+
+// clusterHeaderRoutes returns the routes sending requests to an HTTP port of the host, arriving from the given gateways,
+// to the subset of the backend cluster named by the service's ClusterHeader. They must precede the port's other routes.
+func clusterHeaderRoutes(globalService *datamodel.GlobalService, p datamodel.Port, gateways []string, host string) []*istioapi.HTTPRoute {
+	if globalService.ClusterHeader == "" || passthrough(globalService, p) || !istioconfig.ParseProtocol(p.Protocol).IsHTTP() {
+		return nil
+	}
+
+	// Istio requires header names in lower case
+	header := strings.ToLower(globalService.ClusterHeader)
+	routes := make([]*istioapi.HTTPRoute, 0, len(globalService.Backends))
+	for _, cluster := range sortedBackends(globalService) {
+		routes = append(routes, &istioapi.HTTPRoute{
+			Match: []*istioapi.HTTPMatchRequest{{
+				Port:     p.BackendPort,
+				Gateways: gateways,
+				Headers: map[string]*istioapi.StringMatch{
+					header: {MatchType: &istioapi.StringMatch_Exact{Exact: cluster}},
+				},
+			}},
+			Route: []*istioapi.DestinationWeight{{
+				Destination: &istioapi.Destination{
+					Host:   host,
+					Subset: cluster,
+					Port:   &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: p.BackendPort}},
+				},
+				Weight: 100,
+			}},
+		})
+	}
+	return routes
+}
+
+// callerPorts returns the ports callers use to call the service, i.e. the port numbers declared by its ServiceEntry.
+func callerPorts(globalService *datamodel.GlobalService) []datamodel.Port {
+	ports := make([]datamodel.Port, 0, len(globalService.Ports))
+	seen := make(map[uint32]bool, len(globalService.Ports))
+	for _, p := range globalService.Ports {
+		if seen[p.BackendPort] {
+			continue
+		}
+		seen[p.BackendPort] = true
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// weightedRoute validates the service's weights, and returns a func building the route which splits traffic to a port
+// of the host across the subsets of the backend clusters.
+func weightedRoute(globalService *datamodel.GlobalService) (func(host string, port uint32) []*istioapi.DestinationWeight, error) {
+	var total uint32
+	for cluster, weight := range globalService.Weights {
+		if _, found := globalService.Backends[cluster]; !found {
+			return nil, fmt.Errorf("service %q has a weight for cluster %q, which is not one of its backends", globalService.Name, cluster)
+		}
+		total += weight
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("weights of service %q must sum to 100, got %d", globalService.Name, total)
+	}
+
+	return func(host string, port uint32) []*istioapi.DestinationWeight {
+		route := make([]*istioapi.DestinationWeight, 0, len(globalService.Weights))
+		for _, cluster := range sortedBackends(globalService) {
+			weight := globalService.Weights[cluster]
+			if weight == 0 {
+				continue
+			}
+			route = append(route, &istioapi.DestinationWeight{
+				Destination: &istioapi.Destination{
+					Host:   host,
+					Subset: cluster,
+					Port:   &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: port}},
+				},
+				Weight: int32(weight),
+			})
+		}
+		return route
+	}, nil
+}
+
+// subsetRoute returns a func building the route which sends all traffic to a port of the host to the subset of the
+// backend cluster.
+func subsetRoute(cluster string) func(host string, port uint32) []*istioapi.DestinationWeight {
+	return func(host string, port uint32) []*istioapi.DestinationWeight {
+		return []*istioapi.DestinationWeight{{
+			Destination: &istioapi.Destination{
+				Host:   host,
+				Subset: cluster,
+				Port:   &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: port}},
+			},
+			Weight: 100,
+		}}
+	}
+}
+
+// sortedBackends returns the names of the clusters hosting a backend of the service, in sorted order.
+func sortedBackends(globalService *datamodel.GlobalService) []string {
+	clusters := make([]string, 0, len(globalService.Backends))
+	for cluster := range globalService.Backends {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
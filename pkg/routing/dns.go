@@ -0,0 +1,167 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+)
+
+// placeholderPrefix is the /16 placeholder addresses are picked from. It's in the reserved 240.0.0.0/4 block, so it
+// can't clash with a real address; Istio routes HTTP by its Host header, so the address only has to resolve.
+var placeholderPrefix = [2]byte{240, 240}
+
+// GenerateDNSConfig generates, for each cluster, a CoreDNS server block for each DNS suffix of the services the
+// cluster has config for, whose hosts plugin maps each of the services' hosts to the address of its ServiceEntry.
+// Services without an Address get a placeholder, picked deterministically from their name. It returns the names of
+// the clusters in sorted order, along with a map of (cluster name -> server blocks).
+func GenerateDNSConfig(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string, opts Options) ([]string, map[string][]byte, error) {
+	svcs := dm.ListGlobalServices()
+	names := make([]string, 0, len(svcs))
+	for name := range svcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	addresses, err := assignAddresses(svcs, names, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out []string
+	zones := make(map[string][]byte)
+	for _, cluster := range clusters {
+		c := clusterFor(infra, cluster)
+
+		// (DNS suffix -> host -> address) of the hosts the cluster has ServiceEntries for
+		entries := make(map[string]map[string]string)
+		add := func(svc *datamodel.GlobalService, hosts []string, address string) {
+			suffix := opts.domainSuffix(svc)
+			if entries[suffix] == nil {
+				entries[suffix] = make(map[string]string)
+			}
+			for _, host := range hosts {
+				entries[suffix][host] = address
+			}
+		}
+		for _, name := range names {
+			svc := svcs[name]
+			if _, found := svc.Backends[cluster]; !found && !canCall(svc, c) {
+				continue
+			}
+			add(svc, globalHosts(svc, opts), addresses[name])
+			if !opts.ClusterPinnedHosts {
+				continue
+			}
+			for _, backend := range sortedBackends(svc) {
+				if backend != cluster && !canCall(svc, c) {
+					continue
+				}
+				add(svc, pinnedHosts(svc, backend, opts), addresses[pinnedKey(name, backend)])
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		var concat bytes.Buffer
+		for _, suffix := range sortedZones(entries) {
+			hosts := make([]string, 0, len(entries[suffix]))
+			for host := range entries[suffix] {
+				hosts = append(hosts, host)
+			}
+			sort.Strings(hosts)
+
+			fmt.Fprintf(&concat, "%s:53 {\n", suffix)
+			fmt.Fprintf(&concat, "    hosts {\n")
+			for _, host := range hosts {
+				fmt.Fprintf(&concat, "        %s %s\n", entries[suffix][host], host)
+			}
+			fmt.Fprintf(&concat, "    }\n")
+			fmt.Fprintf(&concat, "}\n")
+		}
+		out = append(out, cluster)
+		zones[cluster] = concat.Bytes()
+	}
+	sort.Strings(out)
+	return out, zones, nil
+}
+
+// assignAddresses returns the address each service's hosts resolve to, keyed by service name, along with those of
+// the services' pinned hosts when they're generated. The addresses are assigned over the whole DataModel, so that a
+// host resolves to the same address in every cluster.
+func assignAddresses(svcs map[string]*datamodel.GlobalService, names []string, opts Options) (map[string]string, error) {
+	addresses := make(map[string]string)
+	// (address -> key of the service or pinned hosts it's assigned to)
+	taken := make(map[string]string)
+	for _, name := range names {
+		if address := svcs[name].Address; len(address) > 0 {
+			addresses[name] = address.String()
+			taken[address.String()] = name
+		}
+	}
+
+	// the pinned hosts' ServiceEntries never have an address, each backend gets a placeholder of its own
+	var keys []string
+	for _, name := range names {
+		if _, found := addresses[name]; !found {
+			keys = append(keys, name)
+		}
+		if opts.ClusterPinnedHosts {
+			for _, backend := range sortedBackends(svcs[name]) {
+				keys = append(keys, pinnedKey(name, backend))
+			}
+		}
+	}
+	for _, key := range keys {
+		address := placeholderAddress(key)
+		if other, found := taken[address]; found {
+			return nil, fmt.Errorf("the placeholder address %s of %q is already assigned to %q; set the address of one of the services",
+				address, key, other)
+		}
+		addresses[key] = address
+		taken[address] = key
+	}
+	return addresses, nil
+}
+
+// placeholderAddress returns the address in the placeholder range derived from the hash of the key alone, so that
+// adding or removing services never moves the addresses of others. The network and broadcast addresses of each /24
+// are skipped, as some resolvers reject them.
+func placeholderAddress(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	n := h.Sum32() % (256 * 254)
+	return net.IPv4(placeholderPrefix[0], placeholderPrefix[1], byte(n/254), byte(n%254+1)).String()
+}
+
+// pinnedKey is the key of the address of a service's pinned hosts for the backend in the cluster.
+func pinnedKey(service, cluster string) string {
+	return service + "/" + cluster
+}
+
+func sortedZones(m map[string]map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
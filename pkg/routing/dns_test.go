@@ -0,0 +1,87 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel/mem"
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
+)
+
+func TestPlaceholderAddressesNeverMove(t *testing.T) {
+	infra := mem.Infrastructure(map[string]datamodel.Cluster{"a": {Name: "a", Address: "a.com"}})
+	opts := routing.Options{DomainSuffix: routing.DefaultDomainSuffix}
+
+	before := placeholders(t, infra, opts, "reviews", "ratings")
+	after := placeholders(t, infra, opts, "details", "reviews", "productpage", "ratings")
+	for host, address := range before {
+		if after[host] != address {
+			t.Errorf("adding services moved the placeholder address of %q from %s to %s", host, address, after[host])
+		}
+	}
+}
+
+func TestPlaceholderAddressCollision(t *testing.T) {
+	infra := mem.Infrastructure(map[string]datamodel.Cluster{"a": {Name: "a", Address: "a.com"}})
+	opts := routing.Options{DomainSuffix: routing.DefaultDomainSuffix}
+
+	// a service given the placeholder address of another can't be told apart from it
+	address := placeholders(t, infra, opts, "reviews")["reviews.global"]
+	ratings := service("ratings")
+	ratings.Address = net.ParseIP(address)
+	dm := mem.NewDataModel()
+	for _, svc := range []*datamodel.GlobalService{service("reviews"), ratings} {
+		if err := dm.CreateGlobalService(svc); err != nil {
+			t.Fatalf("could not create service %q: %v", svc.Name, err)
+		}
+	}
+	if _, _, err := routing.GenerateDNSConfig(dm, infra, []string{"a"}, opts); err == nil {
+		t.Errorf("GenerateDNSConfig() succeeded with %q and %q both at %s, want an error", "reviews", "ratings", address)
+	}
+}
+
+// placeholders returns the addresses the hosts of the services resolve to in cluster a.
+func placeholders(t *testing.T, infra datamodel.Infrastructure, opts routing.Options, names ...string) map[string]string {
+	dm := mem.NewDataModel()
+	for _, name := range names {
+		if err := dm.CreateGlobalService(service(name)); err != nil {
+			t.Fatalf("could not create service %q: %v", name, err)
+		}
+	}
+	_, zones, err := routing.GenerateDNSConfig(dm, infra, []string{"a"}, opts)
+	if err != nil {
+		t.Fatalf("GenerateDNSConfig() failed: %v", err)
+	}
+	out := make(map[string]string)
+	for _, line := range strings.Split(string(zones["a"]), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && net.ParseIP(fields[0]) != nil {
+			out[fields[1]] = fields[0]
+		}
+	}
+	return out
+}
+
+func service(name string) *datamodel.GlobalService {
+	return &datamodel.GlobalService{
+		Name:        name,
+		DNSPrefixes: []string{name},
+		Ports:       []datamodel.Port{{Name: "http", ServicePort: 9080, Protocol: "HTTP", BackendPort: 9080}},
+		Backends:    map[string]string{"a": name + ".default.svc.cluster.local"},
+	}
+}
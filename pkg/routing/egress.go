@@ -0,0 +1,94 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// egressGatewayName is the name of the Gateway configuring a calling cluster's egress gateway for the service.
+func egressGatewayName(globalService *datamodel.GlobalService) string {
+	return fmt.Sprintf("cw-%s-egressgateway", globalService.Name)
+}
+
+// egressServerProtocol returns the protocol the egress gateway listens with for a port of the service. The sidecars
+// send it plain traffic, which the DestinationRules encrypt on the way out, unless the service passes TLS through.
+func egressServerProtocol(globalService *datamodel.GlobalService, p datamodel.Port) string {
+	if passthrough(globalService, p) {
+		return gatewayProtocol(p.Protocol)
+	}
+	return p.Protocol
+}
+
+// buildEgressGatewayForGlobalService generates the Gateway used by a cluster calling a service hosted in other
+// clusters to send its traffic through the cluster's egress gateway. The egress gateway accepts the traffic of the
+// sidecars on the ports of the ServiceEntry, which its Kubernetes Service must open (see
+// GenerateEgressGatewayServicePatches), and the DestinationRules originate TLS from there on. Returns nil if the
+// cluster has no egress gateway.
+func buildEgressGatewayForGlobalService(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) (*IstioConfigDescriptor, error) {
+
+	local := clusterFor(infrastructure, localCluster)
+	if local.EgressGateway == nil {
+		return nil, nil
+	}
+
+	hosts := globalHosts(globalService, opts)
+	if opts.ClusterPinnedHosts {
+		for _, cluster := range sortedBackends(globalService) {
+			hosts = append(hosts, pinnedHosts(globalService, cluster, opts)...)
+		}
+	}
+	gateway := &istioapi.Gateway{
+		Selector: gatewaySelector(local.EgressGateway.Selector, local.Revision),
+	}
+	for _, p := range callerPorts(globalService) {
+		server := &istioapi.Server{
+			Port: &istioapi.Port{
+				Number:   p.BackendPort,
+				Protocol: egressServerProtocol(globalService, p),
+				Name:     p.Name,
+			},
+			Hosts: hosts,
+		}
+		if passthrough(globalService, p) {
+			server.Tls = &istioapi.Server_TLSOptions{Mode: istioapi.Server_TLSOptions_PASSTHROUGH}
+		}
+		gateway.Servers = append(gateway.Servers, server)
+	}
+
+	crd := &istioconfig.Config{
+		ConfigMeta: configMeta(istioconfig.Gateway, globalService, egressGatewayName(globalService), local),
+		Spec:       gateway,
+	}
+
+	yaml, err := protoConfigToYAML(istioconfig.Gateway, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IstioConfigDescriptor{
+		Name:    crd.Name,
+		Hosts:   hosts,
+		Config:  crd,
+		Yaml:    yaml,
+		Cluster: localCluster,
+	}, nil
+}
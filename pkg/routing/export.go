@@ -0,0 +1,97 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+)
+
+// canCall reports whether the cluster gets config to call the service: it must both be exported to the cluster,
+// and imported by it.
+func canCall(globalService *datamodel.GlobalService, cluster datamodel.Cluster) bool {
+	return exportedTo(globalService, cluster) && importedBy(globalService, cluster)
+}
+
+// exportedTo reports whether the service's export policy lets the cluster call it. A cluster can call the service
+// if it's named by the policy, or if its labels match the policy's selector.
+func exportedTo(globalService *datamodel.GlobalService, cluster datamodel.Cluster) bool {
+	policy := globalService.ExportPolicy
+	if policy == nil {
+		return true
+	}
+	if contains(policy.Clusters, cluster.Name) {
+		return true
+	}
+	if len(policy.ClusterSelector) == 0 {
+		return false
+	}
+	for k, v := range policy.ClusterSelector {
+		if value, found := cluster.Labels[k]; !found || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// importedBy reports whether the cluster imports the service. Clusters without imports import every service.
+func importedBy(globalService *datamodel.GlobalService, cluster datamodel.Cluster) bool {
+	if len(cluster.Imports) == 0 {
+		return true
+	}
+	for _, pattern := range cluster.Imports {
+		// bad patterns are reported by CheckImports
+		if matched, _ := path.Match(pattern, globalService.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckImports returns an error for each import of the clusters which is not a valid glob, or which matches no
+// service in the DataModel exported to the cluster.
+func CheckImports(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string) []error {
+	svcs := dm.ListGlobalServices()
+	names := make([]string, 0, len(svcs))
+	for name := range svcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, cluster := range clusters {
+		c := clusterFor(infra, cluster)
+		for _, pattern := range c.Imports {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("cluster %q imports %q, which is not a valid glob: %v", cluster, pattern, err))
+				continue
+			}
+			satisfied := false
+			for _, name := range names {
+				if matched, _ := path.Match(pattern, name); matched && exportedTo(svcs[name], c) {
+					satisfied = true
+					break
+				}
+			}
+			if !satisfied {
+				errs = append(errs, fmt.Errorf("cluster %q imports %q, which matches no service exported to it", cluster, pattern))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,266 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// Outlier detection settings used to eject an unhealthy backend so that its traffic fails over to the others.
+const (
+	failoverConsecutiveErrors  = 5
+	failoverInterval           = 10 * time.Second
+	failoverBaseEjectionTime   = 30 * time.Second
+	failoverMaxEjectionPercent = 100
+)
+
+// callsLocalBackend reports whether the sidecars of a backend cluster call the local backend straight through the
+// "-remote" virtual service. Otherwise they call the service through a ServiceEntry which also holds the gateways of
+// the other backend clusters, so that the service can fail over to them, or split or steer traffic across them.
+func callsLocalBackend(globalService *datamodel.GlobalService) bool {
+	return !globalService.Failover && len(globalService.Weights) == 0 && globalService.ClusterHeader == ""
+}
+
+// buildConfigsForLocalService generates the config used by a cluster calling a service with a backend in that cluster,
+// when its sidecars don't simply call the local backend (see callsLocalBackend). In place of the ServiceEntry for only
+// the local backend we generate one with the local backend first followed by the ingress gateways of the other backend
+// clusters, along with DestinationRules. When the service fails over, they eject unhealthy endpoints and prefer those
+// in the locality of the calling cluster; when it splits or steers traffic, they define a subset per backend cluster,
+// originating TLS to the gateways of the others, and a virtual service routes the requests to the subsets.
+func buildConfigsForLocalService(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) ([]*IstioConfigDescriptor, error) {
+
+	if globalService.Failover && globalService.TLS != nil && globalService.TLS.Mode != datamodel.TLSModePassthrough {
+		// we'd need to originate TLS to the remote endpoints, but not the local one, with a single DestinationRule
+		return nil, fmt.Errorf("service %q cannot fail over when TLS is terminated at the ingress gateway", globalService.Name)
+	}
+
+	local := clusterFor(infrastructure, localCluster)
+	if globalService.Failover && local.Locality == "" {
+		return nil, fmt.Errorf("service %q fails over, which requires the locality of cluster %q", globalService.Name, localCluster)
+	}
+
+	hosts := globalHosts(globalService, opts)
+	serviceEntry := &istioapi.ServiceEntry{
+		Hosts:    hosts,
+		Location: istioapi.ServiceEntry_MESH_INTERNAL,
+	}
+
+	if len(globalService.Address) > 0 {
+		serviceEntry.Addresses = []string{globalService.Address.String()}
+	}
+
+	localPortMap := make(map[string]uint32)
+	for _, p := range globalService.Ports {
+		serviceEntry.Ports = append(serviceEntry.Ports, &istioapi.Port{
+			Number:   p.BackendPort,
+			Protocol: p.Protocol,
+			Name:     p.Name,
+		})
+		localPortMap[p.Name] = p.BackendPort
+	}
+
+	// The local backend comes first, followed by the gateways of the other backend clusters
+	serviceEntry.Endpoints = append(serviceEntry.Endpoints, &istioapi.ServiceEntry_Endpoint{
+		Address: globalService.Backends[localCluster],
+		Ports:   localPortMap,
+		Labels:  map[string]string{clusterLabel: localCluster},
+	})
+	// Failover prefers the endpoints in the locality of the calling cluster, so they all need one; otherwise only the
+	// gateway addresses with a locality get one, as in the ServiceEntries of the callers
+	localities := []string{""}
+	if globalService.Failover {
+		localities[0] = local.Locality
+	}
+
+	var errs error
+	failoverLocality := ""
+	for _, cluster := range sortedBackends(globalService) {
+		if cluster == localCluster {
+			continue
+		}
+		remote := clusterFor(infrastructure, cluster)
+		if globalService.Failover && remote.Locality == "" {
+			errs = multierror.Append(errs, fmt.Errorf("service %q fails over, which requires the locality of cluster %q", globalService.Name, cluster))
+			continue
+		}
+		endpoints, addressLocalities, err := gatewayEndpoints(globalService, infrastructure, cluster)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		serviceEntry.Endpoints = append(serviceEntry.Endpoints, endpoints...)
+		for _, locality := range addressLocalities {
+			if locality == "" && globalService.Failover {
+				locality = remote.Locality
+			}
+			localities = append(localities, locality)
+		}
+		if globalService.Failover && failoverLocality == "" && region(remote.Locality) != region(local.Locality) {
+			failoverLocality = region(remote.Locality)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	serviceEntry.Resolution = resolution(serviceEntry.Endpoints)
+
+	serviceEntryCRD := &istioconfig.Config{
+		ConfigMeta: configMeta(istioconfig.ServiceEntry, globalService, fmt.Sprintf("cw-%s-serviceentry", globalService.Name), local),
+		Spec:       serviceEntry,
+	}
+
+	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD, endpointLocalities(localities),
+		exportTo(globalService, local))
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*IstioConfigDescriptor{{
+		Name:    serviceEntryCRD.Name,
+		Hosts:   hosts,
+		Config:  serviceEntryCRD,
+		Yaml:    serviceEntryYAML,
+		Cluster: localCluster,
+	}}
+
+	steered := len(globalService.Weights) > 0 || globalService.ClusterHeader != ""
+	for _, host := range hosts {
+		destinationRule := &istioapi.DestinationRule{Host: host}
+		patches := []specPatch{exportTo(globalService, local)}
+		if globalService.Failover {
+			destinationRule.TrafficPolicy = &istioapi.TrafficPolicy{
+				OutlierDetection: &istioapi.OutlierDetection{
+					ConsecutiveErrors:  failoverConsecutiveErrors,
+					Interval:           types.DurationProto(failoverInterval),
+					BaseEjectionTime:   types.DurationProto(failoverBaseEjectionTime),
+					MaxEjectionPercent: failoverMaxEjectionPercent,
+				},
+			}
+			patches = append(patches, localityFailover(region(local.Locality), failoverLocality))
+		}
+		if steered {
+			policy, err := tlsOriginationPolicy(globalService, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, cluster := range sortedBackends(globalService) {
+				subset := &istioapi.Subset{
+					Name:   cluster,
+					Labels: map[string]string{clusterLabel: cluster},
+				}
+				// TLS is originated to the gateways of the other backend clusters, the local backend is called as is
+				if cluster != localCluster {
+					subset.TrafficPolicy = policy
+				}
+				destinationRule.Subsets = append(destinationRule.Subsets, subset)
+			}
+		}
+
+		destinationRuleCRD := &istioconfig.Config{
+			ConfigMeta: configMeta(istioconfig.DestinationRule, globalService,
+				fmt.Sprintf("cw-%s-destinationrule", strings.Replace(host, ".", "-", -1)), local),
+			Spec: destinationRule,
+		}
+
+		destinationRuleYAML, err := protoConfigToYAML(istioconfig.DestinationRule, destinationRuleCRD, patches...)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &IstioConfigDescriptor{
+			Name:    destinationRuleCRD.Name,
+			Hosts:   []string{host},
+			Config:  destinationRuleCRD,
+			Yaml:    destinationRuleYAML,
+			Cluster: localCluster,
+		})
+	}
+
+	// The "-remote" virtual service doesn't serve the sidecars of the cluster, so traffic is split or steered, and the
+	// traffic policy applied, here. Faults are only injected into the requests of callers in other clusters.
+	if steered || globalService.TrafficPolicy != nil {
+		// Without weights, requests go to the local backend, or with failover to the nearest healthy one
+		route := routeToBackend
+		if len(globalService.Weights) > 0 {
+			if route, err = weightedRoute(globalService); err != nil {
+				return nil, err
+			}
+		} else if steered && !globalService.Failover {
+			route = subsetRoute(localCluster)
+		}
+
+		caller := local
+		// the egress gateway only carries the traffic of clusters without a backend
+		caller.EgressGateway = nil
+		virtualService, err := callerVirtualService(globalService, caller, hosts,
+			fmt.Sprintf("cw-%s-virtualservice-local", globalService.Name), route, true, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, virtualService)
+	}
+	return out, nil
+}
+
+// region returns the region of a locality of the form region/zone/subzone.
+func region(locality string) string {
+	return strings.SplitN(locality, "/", 2)[0]
+}
+
+// endpointLocalities sets the locality of each of a ServiceEntry's endpoints, in order. Endpoints with an empty
+// locality are left without one.
+func endpointLocalities(localities []string) specPatch {
+	return func(spec map[string]interface{}) {
+		endpoints, _ := spec["endpoints"].([]interface{})
+		for i, e := range endpoints {
+			if endpoint, ok := e.(map[string]interface{}); ok && i < len(localities) && localities[i] != "" {
+				endpoint["locality"] = localities[i]
+			}
+		}
+	}
+}
+
+// localityFailover sends traffic from the region of the calling cluster to the given region once the endpoints in the
+// calling cluster's locality are ejected. Without a region to fail over to we rely on Istio's default priorities.
+func localityFailover(from, to string) specPatch {
+	return func(spec map[string]interface{}) {
+		if to == "" {
+			return
+		}
+		policy, _ := spec["trafficPolicy"].(map[string]interface{})
+		if policy == nil {
+			return
+		}
+		policy["loadBalancer"] = map[string]interface{}{
+			"localityLbSetting": map[string]interface{}{
+				"failover": []interface{}{
+					map[string]interface{}{"from": from, "to": to},
+				},
+			},
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+)
+
+// DefaultGatewayService is the namespace/name of the Kubernetes Service of a cluster's ingress gateway.
+const DefaultGatewayService = "istio-system/istio-ingressgateway"
+
+// The ports the Kubernetes Services of Istio's ingress and egress gateways open by default, which the patches leave
+// alone: Service ports are merged by port number, so a patch would rename them and could reset their target ports,
+// e.g. 80 -> 8080 on Istio 1.6+.
+var (
+	defaultIngressGatewayPorts = map[uint32]bool{80: true, 443: true, 15443: true, 31400: true}
+	defaultEgressGatewayPorts  = map[uint32]bool{80: true, 443: true, 15443: true}
+)
+
+// GenerateGatewayServicePatches generates, for each cluster hosting a backend of a service in the DataModel, a strategic
+// merge patch for the Kubernetes Service of its ingress gateway which opens the ports its Gateways listen on.
+// It returns the names of the clusters in sorted order, along with a map of (cluster name -> patch).
+func GenerateGatewayServicePatches(dm datamodel.DataModel, infra datamodel.Infrastructure, opts Options) ([]string, map[string][]byte, error) {
+	var errs error
+	ports := make(map[string]map[uint32]bool)
+	for _, svc := range dm.ListGlobalServices() {
+		gateways, err := buildIstioGatewayForGlobalService(svc, infra, opts)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrap(err, "could not construct gateways"))
+			continue
+		}
+		for cluster, gateway := range gateways {
+			if ports[cluster] == nil {
+				ports[cluster] = make(map[uint32]bool)
+			}
+			for _, server := range gateway.Config.Spec.(*istioapi.Gateway).Servers {
+				ports[cluster][server.Port.Number] = true
+			}
+		}
+	}
+
+	clusters, out, err := servicePatches(infra, ports, defaultIngressGatewayPorts, gatewayService)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return clusters, out, errs
+}
+
+// GenerateEgressGatewayServicePatches generates, for each of the clusters calling a service in the DataModel through
+// its egress gateway, a strategic merge patch for the Kubernetes Service of the egress gateway which opens the ports
+// the sidecars send it the service's traffic on, i.e. the ports of the service's ServiceEntry.
+// It returns the names of the clusters in sorted order, along with a map of (cluster name -> patch).
+func GenerateEgressGatewayServicePatches(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string) ([]string, map[string][]byte, error) {
+	ports := make(map[string]map[uint32]bool)
+	for _, svc := range dm.ListGlobalServices() {
+		for _, cluster := range clusters {
+			c := clusterFor(infra, cluster)
+			// the egress gateway only carries the traffic of clusters without a backend
+			if _, found := svc.Backends[cluster]; found || c.EgressGateway == nil || !canCall(svc, c) {
+				continue
+			}
+			if ports[cluster] == nil {
+				ports[cluster] = make(map[uint32]bool)
+			}
+			for _, p := range callerPorts(svc) {
+				ports[cluster][p.BackendPort] = true
+			}
+		}
+	}
+	return servicePatches(infra, ports, defaultEgressGatewayPorts, egressGatewayService)
+}
+
+// servicePatches returns the names of the clusters in sorted order, along with the patch of the given gateway Service
+// of each cluster which opens the cluster's ports. The ports the Service opens by default are left out, along with
+// the clusters needing no other.
+func servicePatches(infra datamodel.Infrastructure, ports map[string]map[uint32]bool, defaults map[uint32]bool,
+	service func(datamodel.Cluster) (namespace, name string)) ([]string, map[string][]byte, error) {
+
+	var errs error
+	clusters := make([]string, 0, len(ports))
+	out := make(map[string][]byte, len(ports))
+	for cluster, open := range ports {
+		numbers := make([]uint32, 0, len(open))
+		for port := range open {
+			if !defaults[port] {
+				numbers = append(numbers, port)
+			}
+		}
+		if len(numbers) == 0 {
+			continue
+		}
+		namespace, name := service(clusterFor(infra, cluster))
+		patch, err := gatewayServicePatch(namespace, name, sortPorts(numbers))
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		clusters = append(clusters, cluster)
+		out[cluster] = patch
+	}
+	sort.Strings(clusters)
+	return clusters, out, errs
+}
+
+// gatewayServicePatch returns the patch of the gateway Service which opens the ports. Service ports are merged by port
+// number, so a port the Service already opens is renamed, but keeps its target port, which the patch leaves unset;
+// new ports target the same port of the gateway pods.
+func gatewayServicePatch(namespace, name string, ports []uint32) ([]byte, error) {
+	servicePorts := make([]interface{}, 0, len(ports))
+	for _, port := range ports {
+		servicePorts = append(servicePorts, map[string]interface{}{
+			"name":     fmt.Sprintf("cw-%d", port),
+			"port":     port,
+			"protocol": "TCP",
+		})
+	}
+
+	patch := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": map[string]interface{}{
+			"ports": servicePorts,
+		},
+	}
+	return yaml.Marshal(patch)
+}
+
+// gatewayService returns the namespace and name of the cluster's ingress gateway Service.
+func gatewayService(cluster datamodel.Cluster) (namespace, name string) {
+	service := cluster.GatewayService
+	if service == "" {
+		service = DefaultGatewayService
+	}
+	if i := strings.Index(service, "/"); i >= 0 {
+		return service[:i], service[i+1:]
+	}
+	// a bare name is in the default namespace of the ingress gateway
+	return strings.SplitN(DefaultGatewayService, "/", 2)[0], service
+}
+
+// egressGatewayService returns the namespace and name of the cluster's egress gateway Service, taken from its host.
+func egressGatewayService(cluster datamodel.Cluster) (namespace, name string) {
+	return egressGatewayNamespace(cluster), strings.SplitN(cluster.EgressGateway.Host, ".", 2)[0]
+}
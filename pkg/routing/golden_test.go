@@ -0,0 +1,114 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel/mem"
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the generated config")
+
+// goldenInputs are the directories in testdata/ holding a services.json, along with the directory holding the
+// clusters.json the config is generated for.
+var goldenInputs = []struct {
+	services string
+	clusters string
+}{
+	{services: "cli", clusters: "cli"},
+	{services: "split-bookinfo", clusters: "cli"},
+	{services: "features", clusters: "features"},
+}
+
+func TestGoldenConfigs(t *testing.T) {
+	for _, input := range goldenInputs {
+		t.Run(input.services, func(t *testing.T) {
+			dir := filepath.Join("..", "..", "testdata", input.services)
+			names, infra := loadClusters(t, filepath.Join("..", "..", "testdata", input.clusters, "clusters.json"))
+			dm := loadServices(t, filepath.Join(dir, "services.json"))
+
+			svcs, cfgs, err := routing.GenerateConfigs(dm, infra, names, routing.Options{DomainSuffix: routing.DefaultDomainSuffix})
+			if err != nil {
+				t.Fatalf("GenerateConfigs() failed: %v", err)
+			}
+			var out bytes.Buffer
+			routing.PrintConfigs(&out, svcs, cfgs, "", "")
+			got := out.Bytes()
+
+			golden := filepath.Join(dir, "golden.yaml")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatalf("could not write %q: %v", golden, err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("could not read %q, run `make golden` to generate it: %v", golden, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("generated config differs from %q; run `make golden` if the change is intended, got:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// loadClusters reads the clusters from the file, returning their names in sorted order.
+func loadClusters(t *testing.T, path string) ([]string, datamodel.Infrastructure) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read %q: %v", path, err)
+	}
+	var clusters []datamodel.Cluster
+	if err := json.Unmarshal(contents, &clusters); err != nil {
+		t.Fatalf("could not unmarshal %q: %v", path, err)
+	}
+	names := make([]string, 0, len(clusters))
+	infra := make(map[string]datamodel.Cluster, len(clusters))
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+		infra[cluster.Name] = cluster
+	}
+	sort.Strings(names)
+	return names, mem.Infrastructure(infra)
+}
+
+// loadServices reads the services from the file.
+func loadServices(t *testing.T, path string) datamodel.DataModel {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read %q: %v", path, err)
+	}
+	var services []datamodel.GlobalService
+	if err := json.Unmarshal(contents, &services); err != nil {
+		t.Fatalf("could not unmarshal %q: %v", path, err)
+	}
+	dm := mem.NewDataModel()
+	for i := range services {
+		if err := dm.CreateGlobalService(&services[i]); err != nil {
+			t.Fatalf("could not create service %q: %v", services[i].Name, err)
+		}
+	}
+	return dm
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	// dnsNameLabel matches a label of a DNS name, as per RFC 1123; DNS names are case-insensitive.
+	dnsNameLabel = regexp.MustCompile(`^(?i)[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	// kubernetesName matches a valid Kubernetes Service or Namespace name, which are lowercase DNS labels.
+	kubernetesName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	// numericLabel matches a label of digits only.
+	numericLabel = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// ValidateAddress checks that the address of a cluster's ingress gateway is an IPv4 or IPv6 address, or a DNS name
+// as per RFC 1123.
+func ValidateAddress(address string) error {
+	if address == "" {
+		return errors.New("address is required")
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	name := strings.TrimSuffix(address, ".")
+	if len(name) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", address)
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if !dnsNameLabel.MatchString(label) {
+			return fmt.Errorf("%q is neither an IP address nor a DNS name: invalid label %q", address, label)
+		}
+	}
+	// top-level domains aren't numeric, so this is a malformed IP address, e.g. 10.0.0.256
+	if last := labels[len(labels)-1]; numericLabel.MatchString(last) {
+		return fmt.Errorf("%q is neither an IP address nor a DNS name: numeric top-level label %q", address, last)
+	}
+	return nil
+}
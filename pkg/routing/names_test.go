@@ -0,0 +1,56 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{address: "10.0.0.1", valid: true},
+		{address: "2001:db8::1", valid: true},
+		{address: "a.com", valid: true},
+		{address: "gateway.eu-west1.example.com.", valid: true},
+		{address: "Gateway.Example.COM", valid: true},
+		{address: "1password.com", valid: true},
+		{address: "gw.1", valid: false},
+		{address: "", valid: false},
+		{address: "10.0.0.256", valid: false},
+		{address: "300.1.1.1", valid: false},
+		{address: "1.2.3", valid: false},
+		{address: "1.2.3.4.5", valid: false},
+		{address: "foo_bar.com", valid: false},
+		{address: "-a.com", valid: false},
+		{address: "a..com", valid: false},
+		{address: strings.Repeat("a", 64) + ".com", valid: false},
+		{address: strings.Repeat("a.", 127) + "com", valid: false},
+	}
+	for _, tt := range tests {
+		err := routing.ValidateAddress(tt.address)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateAddress(%q) = %v, want nil", tt.address, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ValidateAddress(%q) = nil, want an error", tt.address)
+		}
+	}
+}
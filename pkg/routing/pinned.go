@@ -0,0 +1,169 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// pinnedHosts returns the names which call the service's backend in the given cluster only, e.g. foo.b.global.
+func pinnedHosts(globalService *datamodel.GlobalService, cluster string, opts Options) []string {
+	suffix := opts.domainSuffix(globalService)
+	hosts := make([]string, 0, len(globalService.DNSPrefixes))
+	for _, dnsPrefix := range globalService.DNSPrefixes {
+		hosts = append(hosts, fmt.Sprintf("%s.%s.%s", dnsPrefix, cluster, suffix))
+	}
+	return hosts
+}
+
+// buildClusterPinnedConfigs generates the config used by a cluster to call the backend of a service in each backend
+// cluster by its pinned hosts. Every backend gets a ServiceEntry of its own: a local backend is called directly, while
+// a remote one is called through the ingress gateway of its cluster, whose Gateway also serves the pinned hosts.
+func buildClusterPinnedConfigs(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) ([]*IstioConfigDescriptor, error) {
+
+	local := clusterFor(infrastructure, localCluster)
+	if _, found := globalService.Backends[localCluster]; found {
+		// the egress gateway is only configured for the service in clusters calling it from outside
+		local.EgressGateway = nil
+	}
+
+	var out []*IstioConfigDescriptor
+	for _, cluster := range sortedBackends(globalService) {
+		if cluster != localCluster && !canCall(globalService, local) {
+			continue
+		}
+		hosts := pinnedHosts(globalService, cluster, opts)
+
+		serviceEntry, err := buildPinnedServiceEntry(globalService, local, cluster, hosts, infrastructure)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, serviceEntry)
+
+		if cluster == localCluster {
+			// the "-remote" virtual service of the local backend serves the pinned hosts as well, unless the service
+			// fails over, in which case the ServiceEntry sends them to the local backend
+			continue
+		}
+
+		if local.EgressGateway != nil || globalService.TrafficPolicy != nil {
+			virtualService, err := callerVirtualService(globalService, local, hosts,
+				fmt.Sprintf("cw-%s-%s-virtualservice-local", globalService.Name, cluster), routeToBackend, false, true)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, virtualService)
+		}
+
+		for _, host := range hosts {
+			policy, err := tlsOriginationPolicy(globalService, host)
+			if err != nil {
+				return nil, err
+			}
+			if policy == nil {
+				continue
+			}
+
+			destinationRuleCRD := &istioconfig.Config{
+				ConfigMeta: configMeta(istioconfig.DestinationRule, globalService,
+					fmt.Sprintf("cw-%s-destinationrule", strings.Replace(host, ".", "-", -1)), local),
+				Spec: &istioapi.DestinationRule{
+					Host:          host,
+					TrafficPolicy: policy,
+				},
+			}
+
+			destinationRuleYAML, err := protoConfigToYAML(istioconfig.DestinationRule, destinationRuleCRD,
+				exportTo(globalService, local))
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, &IstioConfigDescriptor{
+				Name:    destinationRuleCRD.Name,
+				Hosts:   []string{host},
+				Config:  destinationRuleCRD,
+				Yaml:    destinationRuleYAML,
+				Cluster: localCluster,
+			})
+		}
+	}
+	return out, nil
+}
+
+// buildPinnedServiceEntry generates the ServiceEntry for the pinned hosts of the backend in the given cluster.
+func buildPinnedServiceEntry(globalService *datamodel.GlobalService, local datamodel.Cluster, cluster string,
+	hosts []string, infrastructure datamodel.Infrastructure) (*IstioConfigDescriptor, error) {
+
+	serviceEntry := &istioapi.ServiceEntry{
+		Hosts:    hosts,
+		Location: istioapi.ServiceEntry_MESH_EXTERNAL,
+	}
+
+	endpointPortMap := make(map[string]uint32)
+	for _, p := range globalService.Ports {
+		serviceEntry.Ports = append(serviceEntry.Ports, &istioapi.Port{
+			Number:   p.BackendPort,
+			Protocol: p.Protocol,
+			Name:     p.Name,
+		})
+		endpointPortMap[p.Name] = p.BackendPort
+	}
+
+	var localities []string
+	if cluster == local.Name {
+		serviceEntry.Location = istioapi.ServiceEntry_MESH_INTERNAL
+		serviceEntry.Endpoints = []*istioapi.ServiceEntry_Endpoint{{
+			Address: globalService.Backends[cluster],
+			Ports:   endpointPortMap,
+			Labels:  map[string]string{clusterLabel: cluster},
+		}}
+	} else {
+		var err error
+		serviceEntry.Endpoints, localities, err = gatewayEndpoints(globalService, infrastructure, cluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serviceEntry.Resolution = resolution(serviceEntry.Endpoints)
+
+	serviceEntryCRD := &istioconfig.Config{
+		ConfigMeta: configMeta(istioconfig.ServiceEntry, globalService,
+			fmt.Sprintf("cw-%s-%s-serviceentry", globalService.Name, cluster), local),
+		Spec: serviceEntry,
+	}
+
+	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD, endpointLocalities(localities),
+		exportTo(globalService, local))
+	if err != nil {
+		return nil, err
+	}
+
+	return &IstioConfigDescriptor{
+		Name:    serviceEntryCRD.Name,
+		Hosts:   hosts,
+		Config:  serviceEntryCRD,
+		Yaml:    serviceEntryYAML,
+		Cluster: local.Name,
+	}, nil
+}
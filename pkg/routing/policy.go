@@ -0,0 +1,139 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+)
+
+// routePolicy is a service's TrafficPolicy, parsed into the settings of its HTTP routes.
+type routePolicy struct {
+	timeout *types.Duration
+	retries *istioapi.HTTPRetry
+	retryOn string
+	fault   *istioapi.HTTPFaultInjection
+}
+
+// parseTrafficPolicy validates the service's TrafficPolicy. Returns nil if the service has none.
+func parseTrafficPolicy(globalService *datamodel.GlobalService) (*routePolicy, error) {
+	policy := globalService.TrafficPolicy
+	if policy == nil {
+		return nil, nil
+	}
+
+	out := &routePolicy{}
+	var err error
+	if out.timeout, err = parseDuration(policy.Timeout); err != nil {
+		return nil, fmt.Errorf("invalid timeout for service %q: %v", globalService.Name, err)
+	}
+
+	if policy.Retries != nil {
+		if policy.Retries.Attempts <= 0 {
+			return nil, fmt.Errorf("service %q must retry at least once, got %d attempts", globalService.Name, policy.Retries.Attempts)
+		}
+		out.retries = &istioapi.HTTPRetry{Attempts: policy.Retries.Attempts}
+		if out.retries.PerTryTimeout, err = parseDuration(policy.Retries.PerTryTimeout); err != nil {
+			return nil, fmt.Errorf("invalid per try timeout for service %q: %v", globalService.Name, err)
+		}
+		out.retryOn = policy.Retries.RetryOn
+	}
+
+	if fault := policy.Fault; fault != nil && (fault.Delay != nil || fault.Abort != nil) {
+		out.fault = &istioapi.HTTPFaultInjection{}
+		if fault.Delay != nil {
+			if err := validPercent(fault.Delay.Percent); err != nil {
+				return nil, fmt.Errorf("invalid fault delay for service %q: %v", globalService.Name, err)
+			}
+			delay, err := parseDuration(fault.Delay.Duration)
+			if err != nil || delay == nil {
+				return nil, fmt.Errorf("invalid fault delay for service %q: duration %q", globalService.Name, fault.Delay.Duration)
+			}
+			out.fault.Delay = &istioapi.HTTPFaultInjection_Delay{
+				Percent:       fault.Delay.Percent,
+				HttpDelayType: &istioapi.HTTPFaultInjection_Delay_FixedDelay{FixedDelay: delay},
+			}
+		}
+		if fault.Abort != nil {
+			if err := validPercent(fault.Abort.Percent); err != nil {
+				return nil, fmt.Errorf("invalid fault abort for service %q: %v", globalService.Name, err)
+			}
+			if fault.Abort.HTTPStatus < 200 || fault.Abort.HTTPStatus > 599 {
+				return nil, fmt.Errorf("invalid fault abort for service %q: HTTP status %d", globalService.Name, fault.Abort.HTTPStatus)
+			}
+			out.fault.Abort = &istioapi.HTTPFaultInjection_Abort{
+				Percent:   fault.Abort.Percent,
+				ErrorType: &istioapi.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: fault.Abort.HTTPStatus},
+			}
+		}
+	}
+	return out, nil
+}
+
+// apply sets the timeout and retries of the route, and if asked to the fault to inject. A nil policy does nothing.
+func (p *routePolicy) apply(route *istioapi.HTTPRoute, withFault bool) {
+	if p == nil || route == nil {
+		return
+	}
+	route.Timeout = p.timeout
+	route.Retries = p.retries
+	if withFault {
+		route.Fault = p.fault
+	}
+}
+
+// patch returns the patch setting the parts of the policy the Istio 1.0 API can't represent.
+func (p *routePolicy) patch() specPatch {
+	return func(spec map[string]interface{}) {
+		if p == nil || p.retryOn == "" {
+			return
+		}
+		routes, _ := spec["http"].([]interface{})
+		for _, r := range routes {
+			route, _ := r.(map[string]interface{})
+			if retries, ok := route["retries"].(map[string]interface{}); ok {
+				retries["retryOn"] = p.retryOn
+			}
+		}
+	}
+}
+
+// parseDuration parses a duration of the TrafficPolicy. Returns nil if it's unset.
+func parseDuration(s string) (*types.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("duration %q must be positive", s)
+	}
+	return types.DurationProto(d), nil
+}
+
+func validPercent(percent int32) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, got %d", percent)
+	}
+	return nil
+}
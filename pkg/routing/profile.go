@@ -0,0 +1,232 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+)
+
+// Versions of the Istio networking API we generate config for.
+const (
+	apiVersionV1alpha3 = "v1alpha3"
+	apiVersionV1beta1  = "v1beta1"
+	apiVersionV1       = "v1"
+)
+
+// apiVersions lists, newest first, the release of Istio from which each version of the networking API is preferred.
+var apiVersions = []struct {
+	major, minor int
+	version      string
+}{
+	{1, 22, apiVersionV1},
+	{1, 5, apiVersionV1beta1},
+	{0, 0, apiVersionV1alpha3},
+}
+
+// IstioAPIVersion returns the version of the networking API to generate config for a cluster running the given
+// version of Istio, e.g. "1.5" or "1.22.3". Clusters which don't say what version they run get v1alpha3.
+func IstioAPIVersion(istioVersion string) (string, error) {
+	if istioVersion == "" {
+		return apiVersionV1alpha3, nil
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(istioVersion, "%d.%d", &major, &minor); err != nil {
+		return "", fmt.Errorf("invalid Istio version %q, expected major.minor[.patch]", istioVersion)
+	}
+	for _, v := range apiVersions {
+		if major > v.major || (major == v.major && minor >= v.minor) {
+			return v.version, nil
+		}
+	}
+	return apiVersionV1alpha3, nil
+}
+
+// istioRelease returns the major and minor version of the release of Istio the cluster runs. Clusters which don't say
+// what version they run are taken to run Istio 1.0.
+func istioRelease(cluster datamodel.Cluster) (major, minor int) {
+	if _, err := fmt.Sscanf(cluster.IstioVersion, "%d.%d", &major, &minor); err != nil {
+		// the clusters are validated as they're loaded, so only those without a version get here
+		return 1, 0
+	}
+	return major, minor
+}
+
+// atLeastIstio reports whether the cluster runs the given release of Istio, or a later one.
+func atLeastIstio(cluster datamodel.Cluster, major, minor int) bool {
+	m, n := istioRelease(cluster)
+	return m > major || (m == major && n >= minor)
+}
+
+// checkIstioRelease checks that the cluster runs a release of Istio which supports the fields of the config generated
+// for the service in the cluster. Istio 1.0 in particular rejects the fields added by 1.1.
+func checkIstioRelease(globalService *datamodel.GlobalService, cluster datamodel.Cluster,
+	infrastructure datamodel.Infrastructure, opts Options) error {
+
+	var missing []string
+	require := func(feature string, major, minor int) {
+		if !atLeastIstio(cluster, major, minor) {
+			missing = append(missing, fmt.Sprintf("%s (Istio %d.%d)", feature, major, minor))
+		}
+	}
+
+	if namespaces := exportNamespaces(globalService, cluster); namespaces != nil {
+		require("export_to", 1, 1)
+		for _, ns := range namespaces {
+			if ns != "." {
+				require("export_to of namespace names", 1, 6)
+				break
+			}
+		}
+	}
+	if policy := globalService.TrafficPolicy; policy != nil && policy.Retries != nil && policy.Retries.RetryOn != "" {
+		require("retry_on", 1, 1)
+	}
+	_, backend := globalService.Backends[cluster.Name]
+	if backend && globalService.Failover {
+		require("failover", 1, 1)
+	}
+	if !backend || opts.ClusterPinnedHosts || !callsLocalBackend(globalService) {
+		// the ServiceEntries holding the remote backends set the localities of their gateway addresses
+		for _, remote := range sortedBackends(globalService) {
+			if remote == cluster.Name {
+				continue
+			}
+			addresses, _ := infrastructure.GetIngressGatewayAddresses(remote)
+			if hasLocality(addresses) {
+				require("gateway addresses with a locality", 1, 1)
+				break
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config for service %q in cluster %q, which runs Istio %s, uses %s; set the istio_version of the cluster if it runs a later release",
+		globalService.Name, cluster.Name, istioVersion(cluster), strings.Join(missing, ", "))
+}
+
+// istioVersion returns the release of Istio the cluster runs, for messages.
+func istioVersion(cluster datamodel.Cluster) string {
+	if cluster.IstioVersion == "" {
+		return "1.0"
+	}
+	return cluster.IstioVersion
+}
+
+func hasLocality(addresses []datamodel.GatewayAddress) bool {
+	for _, address := range addresses {
+		if address.Locality != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiVersion returns the version of the networking API to generate config for the cluster in.
+func apiVersion(cluster datamodel.Cluster) string {
+	version, err := IstioAPIVersion(cluster.IstioVersion)
+	if err != nil {
+		// the clusters are validated as they're loaded
+		return apiVersionV1alpha3
+	}
+	return version
+}
+
+// profilePatches returns the patches mapping the fields of the v1alpha3 API we build against to those of the given
+// version of the API.
+func profilePatches(version string) []specPatch {
+	switch version {
+	case apiVersionV1beta1, apiVersionV1:
+		return []specPatch{faultPercentage, consecutive5xxErrors, istioMutual}
+	default:
+		return nil
+	}
+}
+
+// faultPercentage replaces the integer percent of the fault injected by HTTP routes, which was removed from the API,
+// with the percentage which replaced it.
+func faultPercentage(spec map[string]interface{}) {
+	routes, _ := spec["http"].([]interface{})
+	for _, r := range routes {
+		route, _ := r.(map[string]interface{})
+		fault, _ := route["fault"].(map[string]interface{})
+		for _, kind := range []string{"delay", "abort"} {
+			f, ok := fault[kind].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if percent, found := f["percent"]; found {
+				delete(f, "percent")
+				f["percentage"] = map[string]interface{}{"value": percent}
+			}
+		}
+	}
+}
+
+// consecutive5xxErrors renames the deprecated consecutiveErrors of the outlier detection of a DestinationRule to the
+// field which replaced it.
+func consecutive5xxErrors(spec map[string]interface{}) {
+	policy, _ := spec["trafficPolicy"].(map[string]interface{})
+	outlierDetection, _ := policy["outlierDetection"].(map[string]interface{})
+	if errs, found := outlierDetection["consecutiveErrors"]; found {
+		delete(outlierDetection, "consecutiveErrors")
+		outlierDetection["consecutive5xxErrors"] = errs
+	}
+}
+
+// istioMutual switches the TLS settings we spell ISTIO_MUTUAL as for Istio 1.0, i.e. MUTUAL with the certificates
+// Citadel mounted into the proxies, to ISTIO_MUTUAL: the proxies of the releases with the later versions of the API
+// get their certificates over SDS, and have no such files.
+func istioMutual(spec map[string]interface{}) {
+	var settings []map[string]interface{}
+	servers, _ := spec["servers"].([]interface{})
+	for _, s := range servers {
+		server, _ := s.(map[string]interface{})
+		if tls, ok := server["tls"].(map[string]interface{}); ok {
+			settings = append(settings, tls)
+		}
+	}
+	// DestinationRules set TLS per port, for the host or for a subset
+	policies := []interface{}{spec["trafficPolicy"]}
+	subsets, _ := spec["subsets"].([]interface{})
+	for _, s := range subsets {
+		subset, _ := s.(map[string]interface{})
+		policies = append(policies, subset["trafficPolicy"])
+	}
+	for _, p := range policies {
+		policy, _ := p.(map[string]interface{})
+		portLevelSettings, _ := policy["portLevelSettings"].([]interface{})
+		for _, p := range portLevelSettings {
+			portPolicy, _ := p.(map[string]interface{})
+			if tls, ok := portPolicy["tls"].(map[string]interface{}); ok {
+				settings = append(settings, tls)
+			}
+		}
+	}
+
+	for _, tls := range settings {
+		if tls["serverCertificate"] != istioCertChain && tls["clientCertificate"] != istioCertChain {
+			continue
+		}
+		tls["mode"] = datamodel.TLSModeIstioMutual
+		for _, field := range []string{"serverCertificate", "clientCertificate", "privateKey", "caCertificates"} {
+			delete(tls, field)
+		}
+	}
+}
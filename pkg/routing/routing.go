@@ -16,11 +16,15 @@ package routing
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"sort"
 
-	"github.com/pkg/errors"
 	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+	"github.com/pkg/errors"
 
 	"github.com/ghodss/yaml"
 	multierror "github.com/hashicorp/go-multierror"
@@ -36,7 +40,8 @@ type IstioConfigDescriptor struct {
 	Name string
 	// Hosts associated with the resource (applies to Gateway, DestinationRules, VirtualServices)
 	Hosts []string
-	// Config is the configuration associated with the object (proto, plus config meta)
+	// Config is the configuration associated with the object (proto, plus config meta).
+	// It's nil for the Kubernetes objects generated alongside the Istio config, which only have their Yaml.
 	Config *istioconfig.Config
 	// Yaml is the CRD in yaml form
 	Yaml []byte
@@ -46,13 +51,29 @@ type IstioConfigDescriptor struct {
 
 // GenerateConfigs generates configuration for every cluster, service pair in the DataModel.
 // It returns a map of (service name -> (cluster name -> configs))
-func GenerateConfigs(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string) ([]string, map[string]map[string][]*IstioConfigDescriptor, error) {
+func GenerateConfigs(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string, opts Options) ([]string, map[string]map[string][]*IstioConfigDescriptor, error) {
+	if err := CheckGatewayProtocols(dm, infra, clusters); err != nil {
+		return nil, nil, err
+	}
+	return generate(BuildGlobalServiceConfigs, dm, infra, clusters, opts)
+}
+
+// GenerateTeardownConfigs generates the configuration to delete for every cluster, service pair in the DataModel.
+// It returns a map of (service name -> (cluster name -> configs))
+func GenerateTeardownConfigs(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string, opts Options) ([]string, map[string]map[string][]*IstioConfigDescriptor, error) {
+	return generate(RemoveGlobalServiceConfigs, dm, infra, clusters, opts)
+}
+
+// BuildFunc generates the configs for a single service, per cluster.
+type BuildFunc func(globalService *datamodel.GlobalService, clusters []string, infrastructure datamodel.Infrastructure, opts Options) (map[string][]*IstioConfigDescriptor, error)
+
+func generate(build BuildFunc, dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string, opts Options) ([]string, map[string]map[string][]*IstioConfigDescriptor, error) {
 	var errs error
 	svcs := dm.ListGlobalServices()
 	names := make([]string, 0, len(svcs))
 	out := make(map[string]map[string][]*IstioConfigDescriptor, len(svcs))
 	for name, svc := range svcs {
-		cfgs, err := BuildGlobalServiceConfigs(svc, clusters, infra)
+		cfgs, err := build(svc, clusters, infra, opts)
 		if err != nil {
 			errs = multierror.Append(errs, errors.Wrap(err, "could not construct configs"))
 			continue
@@ -65,24 +86,69 @@ func GenerateConfigs(dm datamodel.DataModel, infra datamodel.Infrastructure, clu
 }
 
 // DefaultDomainSuffix is the shared DNS suffix for all such global services.
-// TODO: make this configurable
 const DefaultDomainSuffix = "global"
 
-func BuildGlobalServiceConfigs(globalService *datamodel.GlobalService, clusters []string, infrastructure datamodel.Infrastructure) (map[string][]*IstioConfigDescriptor, error) {
+// revisionLabel is the label Istio puts on the pods of a revisioned install.
+const revisionLabel = "istio.io/rev"
+
+// Options configures the generated config.
+type Options struct {
+	// DomainSuffix is appended to each of a service's DNSPrefixes to build the hosts it can be called by,
+	// e.g. foo.global. A GlobalService's own DomainSuffix takes precedence. Defaults to DefaultDomainSuffix.
+	DomainSuffix string
+	// ClusterPinnedHosts also generates hosts which call the backend in a single cluster, e.g. foo.b.global for
+	// the backend of foo in cluster b.
+	ClusterPinnedHosts bool
+	// StubServices also generates a Kubernetes Service with no selector for each of a service's hosts, so that
+	// kube-dns resolves them, e.g. the Service foo in the namespace global for foo.global.
+	StubServices bool
+	// StubEndpoints also generates Endpoints for the stub Services, holding the IP addresses of the ingress gateways.
+	StubEndpoints bool
+}
+
+// domainSuffix returns the DNS suffix to use for the service's hosts.
+func (o Options) domainSuffix(globalService *datamodel.GlobalService) string {
+	if globalService.DomainSuffix != "" {
+		return globalService.DomainSuffix
+	}
+	if o.DomainSuffix != "" {
+		return o.DomainSuffix
+	}
+	return DefaultDomainSuffix
+}
+
+// globalHosts returns the names the service can be called by from any cluster, e.g. foo.global.
+func globalHosts(globalService *datamodel.GlobalService, opts Options) []string {
+	suffix := opts.domainSuffix(globalService)
+	hosts := make([]string, 0, len(globalService.DNSPrefixes))
+	for _, dnsPrefix := range globalService.DNSPrefixes {
+		hosts = append(hosts, fmt.Sprintf("%s.%s", dnsPrefix, suffix))
+	}
+	return hosts
+}
+
+func BuildGlobalServiceConfigs(globalService *datamodel.GlobalService, clusters []string, infrastructure datamodel.Infrastructure, opts Options) (map[string][]*IstioConfigDescriptor, error) {
+	// Callers' virtual services route to the service's first host
+	if len(globalService.DNSPrefixes) == 0 {
+		return nil, fmt.Errorf("service %q has no dns_prefixes to be called by", globalService.Name)
+	}
+
+	// Check the TLS settings up front, as the config of ports passing TLS through would otherwise not look at them
+	if globalService.TLS != nil {
+		if err := checkTLS(globalService.TLS); err != nil {
+			return nil, errors.Wrapf(err, "invalid TLS for service %q", globalService.Name)
+		}
+	}
+
 	// In an attempt to handle updates, we try to remove the service
 	// generate ingress gateway per cluster
-	gateways, err := buildIstioGatewayForGlobalService(globalService)
+	gateways, err := buildIstioGatewayForGlobalService(globalService, infrastructure, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate a virtual service for each backend cluster/service
-	virtualServices, err := buildVirtualServiceForGlobalService(globalService, gateways)
-	if err != nil {
-		return nil, err
-	}
-
-	serviceEntry, err := buildServiceEntryForGlobalService(globalService, infrastructure)
+	virtualServices, err := buildVirtualServiceForGlobalService(globalService, gateways, infrastructure)
 	if err != nil {
 		return nil, err
 	}
@@ -90,97 +156,152 @@ func BuildGlobalServiceConfigs(globalService *datamodel.GlobalService, clusters
 	backendClusters := make(map[string]bool, len(globalService.Backends))
 	configsToApply := make(map[string][]*IstioConfigDescriptor)
 	// Since we return error above, at this stage, gateways and virtualservices will have same set of clusters
-	for cluster := range globalService.Backends {
+	for _, cluster := range sortedBackends(globalService) {
 		configsToApply[cluster] = []*IstioConfigDescriptor{gateways[cluster], virtualServices[cluster]}
-		backendClusters[cluster] = true;
+		backendClusters[cluster] = true
 	}
 
 	for _, c := range clusters {
-		if !backendClusters[c] {
-			configsToApply[c] = append(configsToApply[c], serviceEntry)
-		} else if se, err := buildServiceEntryForLocalService(globalService, c); err == nil {
-			configsToApply[c] = append(configsToApply[c], se)
+		if backendClusters[c] || canCall(globalService, clusterFor(infrastructure, c)) {
+			if err := checkIstioRelease(globalService, clusterFor(infrastructure, c), infrastructure, opts); err != nil {
+				return nil, err
+			}
 		}
-	}
 
-	return configsToApply, nil
-}
+		if backendClusters[c] {
+			if !callsLocalBackend(globalService) {
+				local, err := buildConfigsForLocalService(globalService, c, infrastructure, opts)
+				if err != nil {
+					return nil, err
+				}
+				configsToApply[c] = append(configsToApply[c], local...)
+			} else if se, err := buildServiceEntryForLocalService(globalService, c, infrastructure, opts); err == nil {
+				configsToApply[c] = append(configsToApply[c], se)
+			}
+			continue
+		}
 
-func removeGlobalService(globalService *datamodel.GlobalService, clusters []string) (map[string][]*IstioConfigDescriptor, error) {
+		// Only the clusters the service is exported to, and which import it, can call it
+		if !canCall(globalService, clusterFor(infrastructure, c)) {
+			continue
+		}
 
-	gateways, err := removeIstioGatewayForGlobalService(globalService)
-	if err != nil {
-		return nil, err
-	}
+		serviceEntry, err := buildServiceEntryForGlobalService(globalService, c, infrastructure, opts)
+		if err != nil {
+			return nil, err
+		}
+		configsToApply[c] = append(configsToApply[c], serviceEntry)
 
-	virtualServices, err := removeVirtualServiceForGlobalService(globalService)
-	if err != nil {
-		return nil, err
-	}
+		// Callers may send traffic to other clusters through an egress gateway
+		egressGateway, err := buildEgressGatewayForGlobalService(globalService, c, infrastructure, opts)
+		if err != nil {
+			return nil, err
+		}
+		if egressGateway != nil {
+			configsToApply[c] = append(configsToApply[c], egressGateway)
+		}
 
-	serviceEntry, err := removeServiceEntryForGlobalService(globalService)
-	if err != nil {
-		return nil, err
+		// Callers split traffic across the backend clusters
+		virtualService, err := buildVirtualServiceForCallers(globalService, c, infrastructure, opts)
+		if err != nil {
+			return nil, err
+		}
+		if virtualService != nil {
+			configsToApply[c] = append(configsToApply[c], virtualService)
+		}
+
+		// Callers originate TLS to the ingress gateways of the backend clusters, and route to subsets of them
+		destinationRules, err := buildDestinationRulesForGlobalService(globalService, c, infrastructure, opts)
+		if err != nil {
+			return nil, err
+		}
+		configsToApply[c] = append(configsToApply[c], destinationRules...)
 	}
 
-	configsToDelete := make(map[string][]*IstioConfigDescriptor)
-	// Since we return error above, at this stage, gateways and virtualservices will have same set of clusters
-	for cluster := range globalService.Backends {
-		configsToDelete[cluster] = []*IstioConfigDescriptor{gateways[cluster], virtualServices[cluster]}
+	// Every cluster can call the backend in a specific cluster by its pinned hosts
+	if opts.ClusterPinnedHosts {
+		for _, c := range clusters {
+			pinned, err := buildClusterPinnedConfigs(globalService, c, infrastructure, opts)
+			if err != nil {
+				return nil, err
+			}
+			configsToApply[c] = append(configsToApply[c], pinned...)
+		}
 	}
 
-	for _, c := range clusters {
-		configsToDelete[c] = append(configsToDelete[c], serviceEntry)
+	// Applications in the clusters which call the service resolve its hosts with kube-dns, through stub Services
+	if opts.StubServices {
+		for _, c := range clusters {
+			if !backendClusters[c] && !canCall(globalService, clusterFor(infrastructure, c)) {
+				continue
+			}
+			stubs, err := buildStubServices(globalService, c, infrastructure, opts)
+			if err != nil {
+				return nil, err
+			}
+			configsToApply[c] = append(configsToApply[c], stubs...)
+		}
 	}
 
-	return configsToDelete, nil
+	return configsToApply, nil
 }
 
-func buildIstioGatewayForGlobalService(globalService *datamodel.GlobalService) (map[string]*IstioConfigDescriptor, error) {
-	// 1. generate ingress gateway
-	gateway := &istioapi.Gateway{
-		Servers:  make([]*istioapi.Server, 0), // We need a server for each port in global service
-		Selector: map[string]string{"istio": "ingressgateway"},
-	}
-
-	hosts := make([]string, 0)
-	for _, dnsPrefix := range globalService.DNSPrefixes {
-		hosts = append(hosts, fmt.Sprintf("%s.%s", dnsPrefix, DefaultDomainSuffix))
-	}
+func buildIstioGatewayForGlobalService(globalService *datamodel.GlobalService, infrastructure datamodel.Infrastructure, opts Options) (map[string]*IstioConfigDescriptor, error) {
 	gatewayName := fmt.Sprintf("cw-%s-gateway", globalService.Name)
 
-	for _, p := range globalService.Ports {
-		server := &istioapi.Server{
-			Port: &istioapi.Port{
-				Number:   p.ServicePort,
-				Protocol: p.Protocol,
-				Name:     p.Name,
-			},
-			Hosts: hosts,
-			// TODO TLS
+	// Each backend cluster gets its own gateway, selecting the ingress gateway pods of that cluster
+	out := make(map[string]*IstioConfigDescriptor)
+	for _, cluster := range sortedBackends(globalService) {
+		hosts := globalHosts(globalService, opts)
+		if opts.ClusterPinnedHosts {
+			hosts = append(hosts, pinnedHosts(globalService, cluster, opts)...)
 		}
-		gateway.Servers = append(gateway.Servers, server)
-	}
 
-	crd := &istioconfig.Config{
-		ConfigMeta: istioconfig.ConfigMeta{
-			Type:      istioconfig.Gateway.Type,
-			Group:     istioconfig.Gateway.Group,
-			Version:   istioconfig.Gateway.Version,
-			Name:      gatewayName,
-			Namespace: "cw",
-			Domain:    "svc.cluster.local",
-		},
-		Spec: gateway,
-	}
+		// We need a server for each port in global service, unless the cluster funnels them through its gateway port
+		c := clusterFor(infrastructure, cluster)
+		ports := globalService.Ports
+		if c.GatewayPort != 0 {
+			if err := checkGatewayPort(globalService, c); err != nil {
+				return nil, err
+			}
+			ports = callerPorts(globalService)
+		}
+		servers := make([]*istioapi.Server, 0, len(ports))
+		for _, p := range ports {
+			server := &istioapi.Server{
+				Port: &istioapi.Port{
+					Number:   serverPort(c, p),
+					Protocol: serverProtocol(globalService, p),
+					Name:     p.Name,
+				},
+				Hosts: hosts,
+			}
+			if passthrough(globalService, p) {
+				server.Tls = &istioapi.Server_TLSOptions{Mode: istioapi.Server_TLSOptions_PASSTHROUGH}
+			} else if globalService.TLS != nil {
+				tls, err := gatewayTLSOptions(globalService.TLS)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid TLS for service %q", globalService.Name)
+				}
+				server.Tls = tls
+			}
+			servers = append(servers, server)
+		}
 
-	yaml, err := protoConfigToYAML(istioconfig.Gateway, crd)
-	if err != nil {
-		return nil, err
-	}
+		gateway := &istioapi.Gateway{
+			Servers:  servers,
+			Selector: gatewaySelector(c.GatewaySelector, c.Revision),
+		}
+		crd := &istioconfig.Config{
+			ConfigMeta: configMeta(istioconfig.Gateway, globalService, gatewayName, c),
+			Spec:       gateway,
+		}
+
+		yaml, err := protoConfigToYAML(istioconfig.Gateway, crd)
+		if err != nil {
+			return nil, err
+		}
 
-	out := make(map[string]*IstioConfigDescriptor)
-	for cluster := range globalService.Backends {
 		out[cluster] = &IstioConfigDescriptor{
 			Name:    gatewayName,
 			Hosts:   hosts,
@@ -193,69 +314,187 @@ func buildIstioGatewayForGlobalService(globalService *datamodel.GlobalService) (
 	return out, nil
 }
 
+// checkGatewayPort checks that the service can be called through the single gateway port of the backend cluster.
+// The ingress gateway tells apart the services sharing the port by host or SNI, so a service can only have one port,
+// and TCP ports must use TLS.
+func checkGatewayPort(globalService *datamodel.GlobalService, cluster datamodel.Cluster) error {
+	ports := callerPorts(globalService)
+	if len(ports) > 1 {
+		return fmt.Errorf("service %q has %d ports, but cluster %q funnels traffic through its gateway port %d, which can carry only one",
+			globalService.Name, len(ports), cluster.Name, cluster.GatewayPort)
+	}
+	for _, p := range ports {
+		if !istioconfig.ParseProtocol(p.Protocol).IsHTTP() && !passthrough(globalService, p) && globalService.TLS == nil {
+			return fmt.Errorf("TCP port %q of service %q must use TLS to be routed through the gateway port %d of cluster %q",
+				p.Name, globalService.Name, cluster.GatewayPort, cluster.Name)
+		}
+	}
+	return nil
+}
+
+// serverPort returns the port the ingress gateway of the cluster listens on for a port of a service.
+func serverPort(cluster datamodel.Cluster, p datamodel.Port) uint32 {
+	if cluster.GatewayPort != 0 {
+		return cluster.GatewayPort
+	}
+	return p.ServicePort
+}
+
+// serverProtocol returns the protocol the ingress gateway listens with for a port of the service.
+func serverProtocol(globalService *datamodel.GlobalService, p datamodel.Port) string {
+	if passthrough(globalService, p) || globalService.TLS != nil {
+		return gatewayProtocol(p.Protocol)
+	}
+	return p.Protocol
+}
+
+// CheckGatewayProtocols checks that the services of the DataModel whose Gateways listen on the same port, of the
+// ingress gateway of a backend cluster or of the egress gateway of a calling cluster, can share it. Istio can't serve a
+// port with more than one protocol, and a gateway tells apart the services on a port by host or SNI, which plain TCP
+// traffic lacks, so services sharing a TCP port must use TLS. Services sharing the gateway port of a cluster must all
+// be HTTP, or all use TLS.
+func CheckGatewayProtocols(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string) error {
+	svcs := dm.ListGlobalServices()
+	names := make([]string, 0, len(svcs))
+	for name := range svcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type listener struct {
+		gateway string
+		cluster string
+		port    uint32
+	}
+	owners := make(map[listener]string)
+	protocols := make(map[listener]istioconfig.Protocol)
+	var errs error
+	listen := func(l listener, name string, protocol istioconfig.Protocol) {
+		owner, found := owners[l]
+		if !found {
+			owners[l] = name
+			protocols[l] = protocol
+			return
+		}
+		if protocols[l] != protocol {
+			errs = multierror.Append(errs, fmt.Errorf("services %q and %q both listen on port %d of the %s gateway of cluster %q, "+
+				"with the protocols %s and %s", owner, name, l.port, l.gateway, l.cluster, protocols[l], protocol))
+		} else if !sharedProtocol(protocol) {
+			errs = multierror.Append(errs, fmt.Errorf("services %q and %q both listen on the %s port %d of the %s gateway of cluster %q, "+
+				"which can't tell their traffic apart without TLS", owner, name, protocol, l.port, l.gateway, l.cluster))
+		}
+	}
+
+	for _, name := range names {
+		svc := svcs[name]
+		for _, cluster := range sortedBackends(svc) {
+			c := clusterFor(infra, cluster)
+			ports := svc.Ports
+			if c.GatewayPort != 0 {
+				ports = callerPorts(svc)
+			}
+			for _, p := range ports {
+				listen(listener{gateway: "ingress", cluster: cluster, port: serverPort(c, p)}, name,
+					istioconfig.ParseProtocol(serverProtocol(svc, p)))
+			}
+		}
+		for _, cluster := range clusters {
+			c := clusterFor(infra, cluster)
+			// the egress gateway only carries the traffic of clusters without a backend
+			if _, found := svc.Backends[cluster]; found || c.EgressGateway == nil || !canCall(svc, c) {
+				continue
+			}
+			for _, p := range callerPorts(svc) {
+				listen(listener{gateway: "egress", cluster: cluster, port: p.BackendPort}, name,
+					istioconfig.ParseProtocol(egressServerProtocol(svc, p)))
+			}
+		}
+	}
+	return errs
+}
+
+// sharedProtocol reports whether a gateway can serve several services on a port with the protocol, telling them
+// apart by host for HTTP, or by SNI for TLS.
+func sharedProtocol(protocol istioconfig.Protocol) bool {
+	return protocol.IsHTTP() || protocol == istioconfig.ProtocolHTTPS || protocol == istioconfig.ProtocolTLS
+}
+
+// gatewaySelector returns the labels of a cluster's gateway pods, belonging to the given revision of Istio.
+func gatewaySelector(labels map[string]string, revision string) map[string]string {
+	selector := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		selector[k] = v
+	}
+	if revision != "" {
+		selector[revisionLabel] = revision
+	}
+	return selector
+}
+
 // Generate a virtual service for each backend cluster/service
 func buildVirtualServiceForGlobalService(globalService *datamodel.GlobalService,
-	gateways map[string]*IstioConfigDescriptor) (map[string]*IstioConfigDescriptor, error) {
+	gateways map[string]*IstioConfigDescriptor, infrastructure datamodel.Infrastructure) (map[string]*IstioConfigDescriptor, error) {
+
+	// Timeouts and retries apply once, to the requests of the sidecars; faults are only injected by callers
+	policy, err := parseTrafficPolicy(globalService)
+	if err != nil {
+		return nil, err
+	}
 
 	out := make(map[string]*IstioConfigDescriptor)
 	var errs error
 
-	for cluster, backendHost := range globalService.Backends {
+	for _, cluster := range sortedBackends(globalService) {
+		backendHost := globalService.Backends[cluster]
+		// When the service fails over, or splits or steers traffic across the backend clusters, sidecars in the cluster
+		// call it through its ServiceEntry, whose endpoints include the gateways of the other backend clusters, rather
+		// than straight through to the local backend
+		fromMesh := callsLocalBackend(globalService)
+		virtualServiceGateways := []string{gateways[cluster].Name}
+		if fromMesh {
+			virtualServiceGateways = append([]string{"mesh"}, virtualServiceGateways...)
+		}
 		virtualService := &istioapi.VirtualService{
 			Hosts:    gateways[cluster].Hosts,
-			Gateways: []string{"mesh", gateways[cluster].Name},
+			Gateways: virtualServiceGateways,
 			Http:     []*istioapi.HTTPRoute{},
-			// TODO: TLS/TCP
+			Tcp:      []*istioapi.TCPRoute{},
+			Tls:      []*istioapi.TLSRoute{},
 		}
-		// Generate a HTTP route for all http ports
-		portMap := make(map[uint32]*istioapi.HTTPRoute)
+		// Generate a HTTP route for all http ports, a TCP route for all tcp ports, and an SNI route for all
+		// ports whose TLS is passed through to the backend. Traffic arrives from the ingress gateway on the service
+		// port, or on the cluster's gateway port if it has one, and from the mesh, if it's served, on the backend port.
+		gatewayPort := clusterFor(infrastructure, cluster).GatewayPort
+		hosts := gateways[cluster].Hosts
+		fromGateway := []string{gateways[cluster].Name}
+		gatewayRoutes := newPortRoutes()
+		meshRoutes := newPortRoutes()
 		for _, p := range globalService.Ports {
-			// TODO: Support other protocols once all pieces are in place
-			if istioconfig.ParseProtocol(p.Protocol).IsHTTP() {
-				portMap[p.ServicePort] = &istioapi.HTTPRoute{
-					Match: []*istioapi.HTTPMatchRequest{{Port: p.ServicePort}},
-					Route: []*istioapi.DestinationWeight{
-						{
-							Destination: &istioapi.Destination{
-								Host: backendHost,
-								Port: &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: p.BackendPort}},
-							},
-							Weight: 100,
-						},
-					},
-				}
-				portMap[p.BackendPort] = &istioapi.HTTPRoute{
-					Match: []*istioapi.HTTPMatchRequest{{Port: p.BackendPort}},
-					Route: []*istioapi.DestinationWeight{
-						{
-							Destination: &istioapi.Destination{
-								Host: backendHost,
-								Port: &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: p.BackendPort}},
-							},
-							Weight: 100,
-						},
-					},
-				}
+			gatewayRoutes.add(globalService, p, p.ServicePort, fromGateway, hosts, backendHost)
+			if gatewayPort != 0 {
+				gatewayRoutes.add(globalService, p, gatewayPort, fromGateway, hosts, backendHost)
+			}
+			if fromMesh {
+				meshRoutes.add(globalService, p, p.BackendPort, []string{"mesh"}, hosts, backendHost)
 			}
 		}
 
-		for _, httpRoute := range portMap {
-			virtualService.Http = append(virtualService.Http, httpRoute)
+		// Requests from other clusters were given their timeout and retries by the callers' sidecars, only those of
+		// the cluster's own sidecars get them here
+		for _, route := range meshRoutes.http {
+			policy.apply(route, false)
 		}
+		gatewayRoutes.appendTo(virtualService)
+		meshRoutes.appendTo(virtualService)
 
 		virtualServiceCRD := &istioconfig.Config{
-			ConfigMeta: istioconfig.ConfigMeta{
-				Type:      istioconfig.VirtualService.Type,
-				Group:     istioconfig.VirtualService.Group,
-				Version:   istioconfig.VirtualService.Version,
-				Name:      fmt.Sprintf("cw-%s-virtualservice-remote", globalService.Name),
-				Namespace: "cw",
-				Domain:    "svc.cluster.local", // TODO: We need to know this from the local cluster
-			},
+			ConfigMeta: configMeta(istioconfig.VirtualService, globalService,
+				fmt.Sprintf("cw-%s-virtualservice-remote", globalService.Name), clusterFor(infrastructure, cluster)),
 			Spec: virtualService,
 		}
 
-		virtualServiceYAML, err := protoConfigToYAML(istioconfig.VirtualService, virtualServiceCRD)
+		virtualServiceYAML, err := protoConfigToYAML(istioconfig.VirtualService, virtualServiceCRD, policy.patch(),
+			exportTo(globalService, clusterFor(infrastructure, cluster)))
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			// Skip the entire virtual service
@@ -274,54 +513,138 @@ func buildVirtualServiceForGlobalService(globalService *datamodel.GlobalService,
 	return out, errs
 }
 
-func buildServiceEntryForGlobalService(globalService *datamodel.GlobalService, infrastructure datamodel.Infrastructure) (*IstioConfigDescriptor, error) {
-	var errs error
-	hosts := make([]string, 0)
-	for _, dnsPrefix := range globalService.DNSPrefixes {
-		hosts = append(hosts, fmt.Sprintf("%s.%s", dnsPrefix, DefaultDomainSuffix))
+// portRoutes holds the routes of each kind of a virtual service, by the port they match.
+type portRoutes struct {
+	http map[uint32]*istioapi.HTTPRoute
+	tcp  map[uint32]*istioapi.TCPRoute
+	tls  map[uint32]*istioapi.TLSRoute
+}
+
+func newPortRoutes() portRoutes {
+	return portRoutes{
+		http: make(map[uint32]*istioapi.HTTPRoute),
+		tcp:  make(map[uint32]*istioapi.TCPRoute),
+		tls:  make(map[uint32]*istioapi.TLSRoute),
+	}
+}
+
+// add adds the route sending the traffic to a port of the service, arriving from the gateways on the given port
+// number, to the backend. Passed through TLS is matched by the SNI of the hosts.
+func (r portRoutes) add(globalService *datamodel.GlobalService, p datamodel.Port, port uint32,
+	gateways, hosts []string, backendHost string) {
+
+	route := routeToBackend(backendHost, p.BackendPort)
+	protocol := istioconfig.ParseProtocol(p.Protocol)
+	switch {
+	case passthrough(globalService, p):
+		r.tls[port] = &istioapi.TLSRoute{
+			Match: []*istioapi.TLSMatchAttributes{{SniHosts: hosts, Port: port, Gateways: gateways}},
+			Route: route,
+		}
+	case protocol.IsHTTP():
+		r.http[port] = &istioapi.HTTPRoute{
+			Match: []*istioapi.HTTPMatchRequest{{Port: port, Gateways: gateways}},
+			Route: route,
+		}
+	case protocol.IsTCP():
+		r.tcp[port] = &istioapi.TCPRoute{
+			Match: []*istioapi.L4MatchAttributes{{Port: port, Gateways: gateways}},
+			Route: route,
+		}
+	}
+}
+
+// appendTo appends the routes to the virtual service. Map iteration order is random, so they're added in port order
+// to keep the output stable.
+func (r portRoutes) appendTo(virtualService *istioapi.VirtualService) {
+	httpPorts := make([]uint32, 0, len(r.http))
+	for port := range r.http {
+		httpPorts = append(httpPorts, port)
+	}
+	for _, port := range sortPorts(httpPorts) {
+		virtualService.Http = append(virtualService.Http, r.http[port])
+	}
+	tcpPorts := make([]uint32, 0, len(r.tcp))
+	for port := range r.tcp {
+		tcpPorts = append(tcpPorts, port)
+	}
+	for _, port := range sortPorts(tcpPorts) {
+		virtualService.Tcp = append(virtualService.Tcp, r.tcp[port])
+	}
+	tlsPorts := make([]uint32, 0, len(r.tls))
+	for port := range r.tls {
+		tlsPorts = append(tlsPorts, port)
+	}
+	for _, port := range sortPorts(tlsPorts) {
+		virtualService.Tls = append(virtualService.Tls, r.tls[port])
+	}
+}
+
+// sortPorts sorts the ports in ascending order, returning them for convenience.
+func sortPorts(ports []uint32) []uint32 {
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i] < ports[j]
+	})
+	return ports
+}
+
+// routeToBackend sends all traffic to the given port of the backend service.
+func routeToBackend(backendHost string, port uint32) []*istioapi.DestinationWeight {
+	return []*istioapi.DestinationWeight{
+		{
+			Destination: &istioapi.Destination{
+				Host: backendHost,
+				Port: &istioapi.PortSelector{Port: &istioapi.PortSelector_Number{Number: port}},
+			},
+			Weight: 100,
+		},
+	}
+}
+
+// resolution returns how the proxies find the addresses of a ServiceEntry's endpoints: STATIC when the endpoints are
+// all IP addresses, DNS when any of them is a name. Proxies use the addresses of DNS endpoints which are IPs as is.
+func resolution(endpoints []*istioapi.ServiceEntry_Endpoint) istioapi.ServiceEntry_Resolution {
+	for _, endpoint := range endpoints {
+		if net.ParseIP(endpoint.Address) == nil {
+			return istioapi.ServiceEntry_DNS
+		}
 	}
+	return istioapi.ServiceEntry_STATIC
+}
+
+// Generate a service entry for a cluster calling a service with no backend in that cluster
+func buildServiceEntryForGlobalService(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) (*IstioConfigDescriptor, error) {
+	var errs error
+	hosts := globalHosts(globalService, opts)
 
 	serviceEntry := &istioapi.ServiceEntry{
-		Hosts:      hosts,
-		Location:   istioapi.ServiceEntry_MESH_EXTERNAL,
-		Resolution: istioapi.ServiceEntry_DNS,
+		Hosts:    hosts,
+		Location: istioapi.ServiceEntry_MESH_EXTERNAL,
 	}
 
 	if len(globalService.Address) > 0 {
 		serviceEntry.Addresses = []string{globalService.Address.String()}
 	}
 
-	endpointPortMap := make(map[string]uint32)
-
 	for _, p := range globalService.Ports {
 		serviceEntry.Ports = append(serviceEntry.Ports, &istioapi.Port{
 			Number:   p.BackendPort,
 			Protocol: p.Protocol,
 			Name:     p.Name,
 		})
-		endpointPortMap[p.Name] = p.ServicePort
-	}
-
-	backendClusters := make([]string, 0)
-	// Add one endpoint for every backend cluster. Traffic will be load balanced across these endpoints
-	for cluster := range globalService.Backends {
-		backendClusters = append(backendClusters, cluster)
 	}
-	sort.SliceStable(backendClusters, func(i, j int) bool {
-		return backendClusters[i] < backendClusters[j]
-	})
 
-	for _, cluster := range backendClusters {
-		gatewayAddress, err := infrastructure.GetIngressGatewayAddress(cluster)
+	// Add one endpoint for every address of every backend cluster. Traffic will be load balanced across these endpoints
+	var localities []string
+	for _, cluster := range sortedBackends(globalService) {
+		endpoints, addressLocalities, err := gatewayEndpoints(globalService, infrastructure, cluster)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			continue
 		}
-		serviceEntry.Endpoints = append(serviceEntry.Endpoints, &istioapi.ServiceEntry_Endpoint{
-			Address: gatewayAddress,
-			Ports:   endpointPortMap,
-			Labels:  map[string]string{"cluster": cluster},
-		})
+		serviceEntry.Endpoints = append(serviceEntry.Endpoints, endpoints...)
+		localities = append(localities, addressLocalities...)
 	}
 
 	// Return error if there are no endpoints for the service entry
@@ -329,19 +652,16 @@ func buildServiceEntryForGlobalService(globalService *datamodel.GlobalService, i
 		return nil, errs
 	}
 
+	serviceEntry.Resolution = resolution(serviceEntry.Endpoints)
+
 	serviceEntryCRD := &istioconfig.Config{
-		ConfigMeta: istioconfig.ConfigMeta{
-			Type:      istioconfig.ServiceEntry.Type,
-			Group:     istioconfig.ServiceEntry.Group,
-			Version:   istioconfig.ServiceEntry.Version,
-			Name:      fmt.Sprintf("cw-%s-serviceentry", globalService.Name),
-			Namespace: "cw",
-			Domain:    "svc.cluster.local", // TODO: We need to know this from the local cluster
-		},
+		ConfigMeta: configMeta(istioconfig.ServiceEntry, globalService,
+			fmt.Sprintf("cw-%s-serviceentry", globalService.Name), clusterFor(infrastructure, localCluster)),
 		Spec: serviceEntry,
 	}
 
-	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD)
+	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD, endpointLocalities(localities),
+		exportTo(globalService, clusterFor(infrastructure, localCluster)))
 	if err != nil {
 		errs = multierror.Append(errs, err)
 		// Skip the entire service entry
@@ -353,21 +673,51 @@ func buildServiceEntryForGlobalService(globalService *datamodel.GlobalService, i
 		Hosts:   hosts,
 		Config:  serviceEntryCRD,
 		Yaml:    serviceEntryYAML,
-		Cluster: "",
+		Cluster: localCluster,
 	}, errs
 }
 
-func buildServiceEntryForLocalService(globalService *datamodel.GlobalService, localCluster string) (*IstioConfigDescriptor, error) {
-	var errs error
-	hosts := make([]string, 0)
-	for _, dnsPrefix := range globalService.DNSPrefixes {
-		hosts = append(hosts, fmt.Sprintf("%s.%s", dnsPrefix, DefaultDomainSuffix))
+// gatewayEndpoints returns a ServiceEntry endpoint for each address of the cluster's ingress gateway, along with the
+// locality of each of the addresses. The endpoints call the gateway on the service ports, or on the cluster's gateway
+// port if it has one.
+func gatewayEndpoints(globalService *datamodel.GlobalService, infrastructure datamodel.Infrastructure,
+	cluster string) ([]*istioapi.ServiceEntry_Endpoint, []string, error) {
+
+	addresses, err := infrastructure.GetIngressGatewayAddresses(cluster)
+	if err != nil {
+		return nil, nil, err
 	}
+	gatewayPort := clusterFor(infrastructure, cluster).GatewayPort
+	ports := make(map[string]uint32, len(globalService.Ports))
+	for _, p := range globalService.Ports {
+		ports[p.Name] = p.ServicePort
+		if gatewayPort != 0 {
+			ports[p.Name] = gatewayPort
+		}
+	}
+
+	endpoints := make([]*istioapi.ServiceEntry_Endpoint, 0, len(addresses))
+	localities := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, &istioapi.ServiceEntry_Endpoint{
+			Address: address.Address,
+			Ports:   ports,
+			Labels:  map[string]string{clusterLabel: cluster},
+		})
+		localities = append(localities, address.Locality)
+	}
+	return endpoints, localities, nil
+}
+
+// Generate a service entry for a cluster calling a service with a backend in that cluster
+func buildServiceEntryForLocalService(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) (*IstioConfigDescriptor, error) {
+	var errs error
+	hosts := globalHosts(globalService, opts)
 
 	serviceEntry := &istioapi.ServiceEntry{
-		Hosts:      hosts,
-		Location:   istioapi.ServiceEntry_MESH_INTERNAL,
-		Resolution: istioapi.ServiceEntry_DNS,
+		Hosts:    hosts,
+		Location: istioapi.ServiceEntry_MESH_INTERNAL,
 	}
 
 	if len(globalService.Address) > 0 {
@@ -385,31 +735,27 @@ func buildServiceEntryForLocalService(globalService *datamodel.GlobalService, lo
 		endpointPortMap[p.Name] = p.BackendPort
 	}
 
-		serviceEntry.Endpoints = append(serviceEntry.Endpoints, &istioapi.ServiceEntry_Endpoint{
-			Address: globalService.Backends[localCluster],
-			Ports:   endpointPortMap,
-			Labels:  map[string]string{"cluster": localCluster},
-		})
-
+	serviceEntry.Endpoints = append(serviceEntry.Endpoints, &istioapi.ServiceEntry_Endpoint{
+		Address: globalService.Backends[localCluster],
+		Ports:   endpointPortMap,
+		Labels:  map[string]string{clusterLabel: localCluster},
+	})
 
 	// Return error if there are no endpoints for the service entry
 	if len(serviceEntry.Endpoints) == 0 {
 		return nil, errs
 	}
 
+	serviceEntry.Resolution = resolution(serviceEntry.Endpoints)
+
 	serviceEntryCRD := &istioconfig.Config{
-		ConfigMeta: istioconfig.ConfigMeta{
-			Type:      istioconfig.ServiceEntry.Type,
-			Group:     istioconfig.ServiceEntry.Group,
-			Version:   istioconfig.ServiceEntry.Version,
-			Name:      fmt.Sprintf("cw-%s-serviceentry", globalService.Name),
-			Namespace: "cw",
-			Domain:    "svc.cluster.local", // TODO: We need to know this from the local cluster
-		},
+		ConfigMeta: configMeta(istioconfig.ServiceEntry, globalService,
+			fmt.Sprintf("cw-%s-serviceentry", globalService.Name), clusterFor(infrastructure, localCluster)),
 		Spec: serviceEntry,
 	}
 
-	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD)
+	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD,
+		exportTo(globalService, clusterFor(infrastructure, localCluster)))
 	if err != nil {
 		errs = multierror.Append(errs, err)
 		// Skip the entire service entry
@@ -421,110 +767,110 @@ func buildServiceEntryForLocalService(globalService *datamodel.GlobalService, lo
 		Hosts:   hosts,
 		Config:  serviceEntryCRD,
 		Yaml:    serviceEntryYAML,
-		Cluster: "",
+		Cluster: localCluster,
 	}, errs
 }
 
-func removeIstioGatewayForGlobalService(globalService *datamodel.GlobalService) (map[string]*IstioConfigDescriptor, error) {
-	gatewayName := fmt.Sprintf("cw-%s-gateway", globalService.Name)
-
-	crd := &istioconfig.Config{
-		ConfigMeta: istioconfig.ConfigMeta{
-			Type:      istioconfig.Gateway.Type,
-			Group:     istioconfig.Gateway.Group,
-			Version:   istioconfig.Gateway.Version,
-			Name:      gatewayName,
-			Namespace: "cw",
-			Domain:    "svc.cluster.local",
-		},
-		Spec: &istioapi.Gateway{},
-	}
-
-	yaml, err := protoConfigToYAML(istioconfig.Gateway, crd)
+// clusterFor returns the named cluster with defaults filled in for the settings it leaves unset.
+func clusterFor(infrastructure datamodel.Infrastructure, name string) datamodel.Cluster {
+	cluster, err := infrastructure.GetCluster(name)
 	if err != nil {
-		return nil, err
+		// clusters the infrastructure doesn't know about get all of the defaults
+		cluster = datamodel.Cluster{Name: name}
 	}
-
-	out := make(map[string]*IstioConfigDescriptor)
-	for cluster := range globalService.Backends {
-		out[cluster] = &IstioConfigDescriptor{
-			Name:    gatewayName,
-			Config:  crd,
-			Yaml:    yaml,
-			Cluster: cluster,
-		}
+	if cluster.Namespace == "" {
+		cluster.Namespace = datamodel.DefaultNamespace
 	}
-
-	return out, nil
-}
-
-// Generate a virtual service for each backend cluster/service
-func removeVirtualServiceForGlobalService(globalService *datamodel.GlobalService) (map[string]*IstioConfigDescriptor, error) {
-	out := make(map[string]*IstioConfigDescriptor)
-	var errs error
-
-	for cluster := range globalService.Backends {
-		virtualServiceCRD := &istioconfig.Config{
-			ConfigMeta: istioconfig.ConfigMeta{
-				Type:      istioconfig.VirtualService.Type,
-				Group:     istioconfig.VirtualService.Group,
-				Version:   istioconfig.VirtualService.Version,
-				Name:      fmt.Sprintf("cw-%s-virtualservice", globalService.Name),
-				Namespace: "cw",
-				Domain:    "svc.cluster.local", // TODO: We need to know this from the local cluster
-			},
-			Spec: &istioapi.VirtualService{},
-		}
-
-		virtualServiceYAML, err := protoConfigToYAML(istioconfig.VirtualService, virtualServiceCRD)
-		if err != nil {
-			errs = multierror.Append(errs, err)
-			// Skip the entire virtual service
-			continue
+	if cluster.Domain == "" {
+		cluster.Domain = datamodel.DefaultDomain
+	}
+	if len(cluster.GatewaySelector) == 0 {
+		cluster.GatewaySelector = map[string]string{"istio": "ingressgateway"}
+	}
+	if cluster.EgressGateway != nil {
+		// copy, so we don't fill in the defaults on the infrastructure's cluster
+		egress := *cluster.EgressGateway
+		if len(egress.Selector) == 0 {
+			egress.Selector = map[string]string{"istio": "egressgateway"}
 		}
-
-		out[cluster] = &IstioConfigDescriptor{
-			Name:    virtualServiceCRD.Name,
-			Config:  virtualServiceCRD,
-			Yaml:    virtualServiceYAML,
-			Cluster: cluster,
+		if egress.Host == "" {
+			egress.Host = "istio-egressgateway.istio-system." + cluster.Domain
 		}
+		cluster.EgressGateway = &egress
 	}
-
-	return out, errs
+	return cluster
 }
 
-func removeServiceEntryForGlobalService(globalService *datamodel.GlobalService) (*IstioConfigDescriptor, error) {
-	serviceEntryCRD := &istioconfig.Config{
-		ConfigMeta: istioconfig.ConfigMeta{
-			Type:      istioconfig.ServiceEntry.Type,
-			Group:     istioconfig.ServiceEntry.Group,
-			Version:   istioconfig.ServiceEntry.Version,
-			Name:      fmt.Sprintf("cw-%s-serviceentry", globalService.Name),
-			Namespace: "cw",
-			Domain:    "svc.cluster.local", // TODO: We need to know this from the local cluster
+// Labels and annotations we put on every config we generate, so they can be found and garbage collected.
+const (
+	managedByLabel        = "app.kubernetes.io/managed-by"
+	managedByValue        = "coddiwomple"
+	serviceLabel          = "coddiwomple.io/service"
+	clusterNameLabel      = "coddiwomple.io/cluster"
+	contentHashAnnotation = "coddiwomple.io/content-hash"
+)
+
+// configMeta returns the metadata for a config named name, of the given type, generated for the service
+// to be applied in the cluster. Its version is that of the API the cluster's release of Istio prefers.
+func configMeta(schema istioconfig.ProtoSchema, globalService *datamodel.GlobalService, name string, cluster datamodel.Cluster) istioconfig.ConfigMeta {
+	return istioconfig.ConfigMeta{
+		Type:      schema.Type,
+		Group:     schema.Group,
+		Version:   apiVersion(cluster),
+		Name:      name,
+		Namespace: cluster.Namespace,
+		Domain:    cluster.Domain,
+		Labels: map[string]string{
+			managedByLabel:   managedByValue,
+			serviceLabel:     globalService.Name,
+			clusterNameLabel: cluster.Name,
 		},
-		Spec: &istioapi.ServiceEntry{},
 	}
+}
 
-	serviceEntryYAML, err := protoConfigToYAML(istioconfig.ServiceEntry, serviceEntryCRD)
+// contentHash returns a hash of the spec, which changes whenever the generated config does.
+func contentHash(spec map[string]interface{}) (string, error) {
+	// encoding/json sorts map keys, so equal specs always hash the same
+	bytes, err := json.Marshal(spec)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	return &IstioConfigDescriptor{
-		Name:    serviceEntryCRD.Name,
-		Config:  serviceEntryCRD,
-		Yaml:    serviceEntryYAML,
-		Cluster: "",
-	}, nil
+	return fmt.Sprintf("%x", sha256.Sum256(bytes)), nil
 }
 
-func protoConfigToYAML(schema istioconfig.ProtoSchema, istioConfigObject *istioconfig.Config) ([]byte, error) {
+// specPatch edits the JSON form of a config's spec before it's serialized. It lets us set fields which are newer than
+// the version of the Istio API we build against.
+type specPatch func(spec map[string]interface{})
+
+func protoConfigToYAML(schema istioconfig.ProtoSchema, istioConfigObject *istioconfig.Config, patches ...specPatch) ([]byte, error) {
 	kubeObject, err := istiocrd.ConvertConfig(schema, *istioConfigObject)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to convert Istio %s object to K8S CRD: %s", schema.Type, err)
 	}
+	// the converted object always has the version of the API we build against, switch it to the config's
+	gvk := kubeObject.GetObjectKind().GroupVersionKind()
+	if istioConfigObject.Version != "" {
+		gvk.Version = istioConfigObject.Version
+	}
+	kubeObject.GetObjectKind().SetGroupVersionKind(gvk)
+
+	spec := kubeObject.GetSpec()
+	for _, patch := range append(patches, profilePatches(gvk.Version)...) {
+		patch(spec)
+	}
+	kubeObject.SetSpec(spec)
+
+	hash, err := contentHash(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash Istio %s object: %s", schema.Type, err)
+	}
+	if istioConfigObject.Annotations == nil {
+		istioConfigObject.Annotations = make(map[string]string, 1)
+	}
+	istioConfigObject.Annotations[contentHashAnnotation] = hash
+	meta := kubeObject.GetObjectMeta()
+	meta.Annotations = istioConfigObject.Annotations
+	kubeObject.SetObjectMeta(meta)
 
 	yamlPayload, err := yaml.Marshal(kubeObject)
 	if err != nil {
@@ -533,6 +879,41 @@ func protoConfigToYAML(schema istioconfig.ProtoSchema, istioConfigObject *istioc
 	return yamlPayload, nil
 }
 
+// PrintConfigs prints the config generated for each service, as returned by GenerateConfigs, with the clusters of
+// each service in sorted order so the output is stable. Non-empty service and cluster filters print only the config
+// for that service and cluster.
+func PrintConfigs(out io.Writer, svcs []string, cfgs map[string]map[string][]*IstioConfigDescriptor, service, cluster string) {
+	for _, svc := range svcs {
+		if service != "" && svc != service {
+			continue
+		}
+
+		fmt.Fprintf(out, "################################################################################\n")
+		fmt.Fprintf(out, "# Configs for Service %q\n", svc)
+		fmt.Fprintf(out, "################################################################################\n")
+		// map iteration order is random, print clusters in sorted order so the output is stable
+		cls := make([]string, 0, len(cfgs[svc]))
+		for cl := range cfgs[svc] {
+			cls = append(cls, cl)
+		}
+		sort.Strings(cls)
+		for _, cl := range cls {
+			if cluster != "" && cl != cluster {
+				continue
+			}
+
+			fmt.Fprintf(out, "####################\n")
+			fmt.Fprintf(out, "# Configs for Cluster %q\n", cl)
+			fmt.Fprintf(out, "####################\n")
+			for _, c := range cfgs[svc][cl] {
+				fmt.Fprint(out, "---\n")
+				fmt.Fprint(out, string(c.Yaml))
+				fmt.Fprint(out, "\n")
+			}
+		}
+	}
+}
+
 func concatenateYAMLs(objs []*IstioConfigDescriptor) []byte {
 	var concat bytes.Buffer
 	for _, obj := range objs {
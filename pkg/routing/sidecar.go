@@ -0,0 +1,230 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+)
+
+// sidecarName is the name of the Sidecar generated in each namespace which calls global services.
+const sidecarName = "cw-sidecar"
+
+// exportTo makes the config generated for the service in the cluster visible only to the namespaces it's exported to.
+func exportTo(globalService *datamodel.GlobalService, cluster datamodel.Cluster) specPatch {
+	return func(spec map[string]interface{}) {
+		namespaces := exportNamespaces(globalService, cluster)
+		if namespaces == nil {
+			return
+		}
+		exportTo := make([]interface{}, 0, len(namespaces))
+		for _, ns := range namespaces {
+			exportTo = append(exportTo, ns)
+		}
+		spec["exportTo"] = exportTo
+	}
+}
+
+// exportNamespaces returns the namespaces the service is exported to in the cluster, including those of the cluster's
+// gateways which carry its traffic: the egress gateway, and the ingress gateway if the cluster hosts a backend of the
+// service. Returns nil if the service is exported to every namespace.
+func exportNamespaces(globalService *datamodel.GlobalService, cluster datamodel.Cluster) []string {
+	if len(globalService.ExportTo) == 0 || contains(globalService.ExportTo, "*") {
+		return nil
+	}
+	namespaces := append([]string(nil), globalService.ExportTo...)
+	if ns := egressGatewayNamespace(cluster); ns != "" && !contains(namespaces, ns) {
+		namespaces = append(namespaces, ns)
+	}
+	if _, backend := globalService.Backends[cluster.Name]; backend {
+		if ns, _ := gatewayService(cluster); !contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// egressGatewayNamespace returns the namespace of the cluster's egress gateway, taken from its host, or "" if it has none.
+func egressGatewayNamespace(cluster datamodel.Cluster) string {
+	if cluster.EgressGateway == nil {
+		return ""
+	}
+	labels := strings.Split(cluster.EgressGateway.Host, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	return labels[1]
+}
+
+// visibleTo reports whether config for the service generated in the cluster is visible to the namespace.
+func visibleTo(globalService *datamodel.GlobalService, cluster datamodel.Cluster, namespace string) bool {
+	namespaces := exportNamespaces(globalService, cluster)
+	if namespaces == nil {
+		return true
+	}
+	return contains(namespaces, namespace) || (namespace == cluster.Namespace && contains(namespaces, "."))
+}
+
+// GenerateSidecars generates, for each cluster, a Sidecar for each namespace a service in the DataModel is exported
+// to, which limits the config of the namespace's sidecars to the global hosts the namespace can call, along with
+// the services in its own namespace and Istio's. It returns the names of the clusters with Sidecars in sorted order,
+// along with a map of (cluster name -> Sidecars).
+func GenerateSidecars(dm datamodel.DataModel, infra datamodel.Infrastructure, clusters []string, opts Options) ([]string, map[string][]byte, error) {
+	svcs := dm.ListGlobalServices()
+	names := make([]string, 0, len(svcs))
+	for name := range svcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs error
+	var out []string
+	sidecars := make(map[string][]byte)
+	for _, cluster := range clusters {
+		c := clusterFor(infra, cluster)
+
+		// the cluster has config for the services it hosts a backend of, or which it can call
+		var callable []string
+		for _, name := range names {
+			if _, found := svcs[name].Backends[cluster]; found || canCall(svcs[name], c) {
+				callable = append(callable, name)
+			}
+		}
+
+		// the namespaces which consume global services are those the services are exported to, other than the gateways'
+		ingressNamespace, _ := gatewayService(c)
+		namespaces := make(map[string]bool)
+		for _, name := range callable {
+			for _, ns := range exportNamespaces(svcs[name], c) {
+				if ns != "." && ns != egressGatewayNamespace(c) && ns != ingressNamespace {
+					namespaces[ns] = true
+				}
+			}
+		}
+		if len(namespaces) == 0 {
+			continue
+		}
+		if !atLeastIstio(c, 1, 1) {
+			errs = multierror.Append(errs, fmt.Errorf("cluster %q runs Istio %s, which has no Sidecar; set the istio_version of the cluster if it runs 1.1 or later",
+				cluster, istioVersion(c)))
+			continue
+		}
+
+		var concat bytes.Buffer
+		for _, ns := range sortedKeys(namespaces) {
+			hosts := []string{"./*", "istio-system/*"}
+			if egressNamespace := egressGatewayNamespace(c); egressNamespace != "" && egressNamespace != "istio-system" {
+				hosts = append(hosts, egressNamespace+"/*")
+			}
+			var global []string
+			for _, name := range callable {
+				svc := svcs[name]
+				if !visibleTo(svc, c, ns) {
+					continue
+				}
+				for _, host := range globalHosts(svc, opts) {
+					global = append(global, fmt.Sprintf("%s/%s", c.Namespace, host))
+				}
+				if backend, found := svc.Backends[cluster]; found {
+					// the virtual service of a local backend routes the global hosts to it
+					global = append(global, "*/"+backend)
+				}
+				if opts.ClusterPinnedHosts {
+					for _, backend := range sortedBackends(svc) {
+						if backend != cluster && !canCall(svc, c) {
+							continue
+						}
+						for _, host := range pinnedHosts(svc, backend, opts) {
+							global = append(global, fmt.Sprintf("%s/%s", c.Namespace, host))
+						}
+					}
+				}
+			}
+			sort.Strings(global)
+
+			sidecar, err := sidecarYAML(c, ns, append(hosts, global...))
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			concat.WriteString("---\n")
+			concat.Write(sidecar)
+		}
+		out = append(out, cluster)
+		sidecars[cluster] = concat.Bytes()
+	}
+	sort.Strings(out)
+	return out, sidecars, errs
+}
+
+// sidecarYAML returns the Sidecar for the namespace of the cluster, limiting its sidecars' egress to the hosts.
+// The Istio API we build against predates the Sidecar, so we build the object by hand.
+func sidecarYAML(cluster datamodel.Cluster, namespace string, hosts []string) ([]byte, error) {
+	egressHosts := make([]interface{}, 0, len(hosts))
+	for _, host := range hosts {
+		egressHosts = append(egressHosts, host)
+	}
+	spec := map[string]interface{}{
+		"egress": []interface{}{
+			map[string]interface{}{"hosts": egressHosts},
+		},
+	}
+	hash, err := contentHash(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash Istio Sidecar object: %s", err)
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "networking.istio.io/" + apiVersion(cluster),
+		"kind":       "Sidecar",
+		"metadata": map[string]interface{}{
+			"name":      sidecarName,
+			"namespace": namespace,
+			"labels": map[string]string{
+				managedByLabel:   managedByValue,
+				clusterNameLabel: cluster.Name,
+			},
+			"annotations": map[string]string{
+				contentHashAnnotation: hash,
+			},
+		},
+		"spec": spec,
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
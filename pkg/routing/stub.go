@@ -0,0 +1,158 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+)
+
+// buildStubServices generates a Kubernetes Service with no selector for each of the service's hosts, so that the
+// applications in the cluster can resolve them with kube-dns: the host foo.global is the Service foo in the namespace
+// global, which kube-dns resolves via the pods' search path. Istio then intercepts the calls to the Service's VIP and
+// routes them by host. Only hosts whose DNS prefix is a single label get a stub.
+//
+// Clusters without a local backend can also get Endpoints for the stubs, holding the IP addresses of the ingress
+// gateways of the backend clusters, for the callers whose traffic Istio forwards to the VIP untouched.
+func buildStubServices(globalService *datamodel.GlobalService, localCluster string,
+	infrastructure datamodel.Infrastructure, opts Options) ([]*IstioConfigDescriptor, error) {
+
+	namespace := opts.domainSuffix(globalService)
+	if !kubernetesName.MatchString(namespace) {
+		return nil, fmt.Errorf("cannot generate stub Services for service %q: its DNS suffix %q is not a valid namespace name",
+			globalService.Name, namespace)
+	}
+	local := clusterFor(infrastructure, localCluster)
+
+	ports := make([]interface{}, 0, len(globalService.Ports))
+	for _, p := range callerPorts(globalService) {
+		ports = append(ports, map[string]interface{}{
+			"name":     p.Name,
+			"port":     p.BackendPort,
+			"protocol": "TCP",
+		})
+	}
+
+	var subsets []interface{}
+	if _, found := globalService.Backends[localCluster]; !found && opts.StubEndpoints {
+		var err error
+		if subsets, err = stubEndpointSubsets(globalService, infrastructure); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []*IstioConfigDescriptor
+	for _, dnsPrefix := range globalService.DNSPrefixes {
+		if !kubernetesName.MatchString(dnsPrefix) {
+			// kube-dns can't resolve names with more labels to a Service
+			continue
+		}
+		host := fmt.Sprintf("%s.%s", dnsPrefix, namespace)
+
+		service, err := stubYAML("Service", globalService, dnsPrefix, namespace, local, "spec",
+			map[string]interface{}{"ports": ports})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &IstioConfigDescriptor{
+			Name:    dnsPrefix,
+			Hosts:   []string{host},
+			Yaml:    service,
+			Cluster: localCluster,
+		})
+
+		if len(subsets) == 0 {
+			continue
+		}
+		endpoints, err := stubYAML("Endpoints", globalService, dnsPrefix, namespace, local, "subsets", subsets)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &IstioConfigDescriptor{
+			Name:    dnsPrefix,
+			Hosts:   []string{host},
+			Yaml:    endpoints,
+			Cluster: localCluster,
+		})
+	}
+	return out, nil
+}
+
+// stubEndpointSubsets returns a subset of Endpoints for each backend cluster, with the IP addresses of its ingress
+// gateway, on the ports the gateway listens on. Addresses which are names can't be Endpoints, and are left out.
+func stubEndpointSubsets(globalService *datamodel.GlobalService, infrastructure datamodel.Infrastructure) ([]interface{}, error) {
+	var subsets []interface{}
+	for _, cluster := range sortedBackends(globalService) {
+		endpoints, _, err := gatewayEndpoints(globalService, infrastructure, cluster)
+		if err != nil {
+			return nil, err
+		}
+		var addresses []interface{}
+		for _, endpoint := range endpoints {
+			if net.ParseIP(endpoint.Address) != nil {
+				addresses = append(addresses, map[string]interface{}{"ip": endpoint.Address})
+			}
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+
+		var ports []interface{}
+		for _, p := range callerPorts(globalService) {
+			ports = append(ports, map[string]interface{}{
+				"name":     p.Name,
+				"port":     endpoints[0].Ports[p.Name],
+				"protocol": "TCP",
+			})
+		}
+		subsets = append(subsets, map[string]interface{}{
+			"addresses": addresses,
+			"ports":     ports,
+		})
+	}
+	return subsets, nil
+}
+
+// stubYAML returns a Kubernetes object of the kind, whose body is stored under the given field, e.g. the spec.
+// It's labelled and annotated like the Istio config we generate.
+func stubYAML(kind string, globalService *datamodel.GlobalService, name, namespace string, cluster datamodel.Cluster,
+	field string, body interface{}) ([]byte, error) {
+
+	hash, err := contentHash(map[string]interface{}{field: body})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash %s object: %s", kind, err)
+	}
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels": map[string]string{
+				managedByLabel:   managedByValue,
+				serviceLabel:     globalService.Name,
+				clusterNameLabel: cluster.Name,
+			},
+			"annotations": map[string]string{
+				contentHashAnnotation: hash,
+			},
+		},
+		field: body,
+	})
+}
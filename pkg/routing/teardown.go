@@ -0,0 +1,170 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// RemoveGlobalServiceConfigs returns, per cluster, the configs to delete to remove the service from every cluster, with
+// empty specs: the output is meant to be fed to `kubectl delete --ignore-not-found -f`. It names every config
+// BuildGlobalServiceConfigs could have generated for the service in each cluster, whatever the options, export policy
+// and imports it was generated with, and doesn't validate the service, so that it can always be torn down. Only the
+// DNS suffix must be the one the config was generated with, as it names the hosts.
+func RemoveGlobalServiceConfigs(globalService *datamodel.GlobalService, clusters []string,
+	infrastructure datamodel.Infrastructure, opts Options) (map[string][]*IstioConfigDescriptor, error) {
+
+	hosts := globalHosts(globalService, opts)
+	allHosts := append([]string(nil), hosts...)
+	for _, cluster := range sortedBackends(globalService) {
+		allHosts = append(allHosts, pinnedHosts(globalService, cluster, opts)...)
+	}
+
+	// the configs of backend clusters, then those of callers, then those of the pinned hosts
+	targets := []teardownTarget{
+		{istioconfig.Gateway, fmt.Sprintf("cw-%s-gateway", globalService.Name), &istioapi.Gateway{}, allHosts},
+		{istioconfig.VirtualService, fmt.Sprintf("cw-%s-virtualservice-remote", globalService.Name), &istioapi.VirtualService{}, allHosts},
+		{istioconfig.ServiceEntry, fmt.Sprintf("cw-%s-serviceentry", globalService.Name), &istioapi.ServiceEntry{}, hosts},
+		{istioconfig.Gateway, egressGatewayName(globalService), &istioapi.Gateway{}, allHosts},
+		{istioconfig.VirtualService, fmt.Sprintf("cw-%s-virtualservice-local", globalService.Name), &istioapi.VirtualService{}, hosts},
+	}
+	for _, cluster := range sortedBackends(globalService) {
+		pinned := pinnedHosts(globalService, cluster, opts)
+		targets = append(targets,
+			teardownTarget{istioconfig.ServiceEntry, fmt.Sprintf("cw-%s-%s-serviceentry", globalService.Name, cluster),
+				&istioapi.ServiceEntry{}, pinned},
+			teardownTarget{istioconfig.VirtualService, fmt.Sprintf("cw-%s-%s-virtualservice-local", globalService.Name, cluster),
+				&istioapi.VirtualService{}, pinned})
+	}
+	for _, host := range allHosts {
+		targets = append(targets, teardownTarget{istioconfig.DestinationRule,
+			fmt.Sprintf("cw-%s-destinationrule", strings.Replace(host, ".", "-", -1)), &istioapi.DestinationRule{}, []string{host}})
+	}
+
+	configsToDelete := make(map[string][]*IstioConfigDescriptor, len(clusters))
+	for _, name := range clusters {
+		cluster := clusterFor(infrastructure, name)
+		for _, target := range targets {
+			cfg, err := teardownConfig(&IstioConfigDescriptor{
+				Name:  target.name,
+				Hosts: target.hosts,
+				Config: &istioconfig.Config{
+					ConfigMeta: configMeta(target.schema, globalService, target.name, cluster),
+					Spec:       target.spec,
+				},
+				Cluster: name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			configsToDelete[name] = append(configsToDelete[name], cfg)
+		}
+
+		stubs, err := teardownStubs(globalService, cluster, opts)
+		if err != nil {
+			return nil, err
+		}
+		configsToDelete[name] = append(configsToDelete[name], stubs...)
+	}
+	return configsToDelete, nil
+}
+
+// teardownTarget names a config to delete, along with the type of its spec.
+type teardownTarget struct {
+	schema istioconfig.ProtoSchema
+	name   string
+	spec   proto.Message
+	hosts  []string
+}
+
+// teardownStubs returns the stub Services and Endpoints to delete for the service in the cluster, see buildStubServices.
+func teardownStubs(globalService *datamodel.GlobalService, cluster datamodel.Cluster, opts Options) ([]*IstioConfigDescriptor, error) {
+	namespace := opts.domainSuffix(globalService)
+	if !kubernetesName.MatchString(namespace) {
+		// no stubs could have been generated
+		return nil, nil
+	}
+
+	var out []*IstioConfigDescriptor
+	for _, dnsPrefix := range globalService.DNSPrefixes {
+		if !kubernetesName.MatchString(dnsPrefix) {
+			continue
+		}
+		host := fmt.Sprintf("%s.%s", dnsPrefix, namespace)
+		for _, stub := range []struct {
+			kind, field string
+			body        interface{}
+		}{
+			{kind: "Service", field: "spec", body: map[string]interface{}{}},
+			{kind: "Endpoints", field: "subsets", body: []interface{}{}},
+		} {
+			yaml, err := stubYAML(stub.kind, globalService, dnsPrefix, namespace, cluster, stub.field, stub.body)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, &IstioConfigDescriptor{
+				Name:    dnsPrefix,
+				Hosts:   []string{host},
+				Yaml:    yaml,
+				Cluster: cluster.Name,
+			})
+		}
+	}
+	return out, nil
+}
+
+// teardownConfig returns a copy of the config with an empty spec.
+func teardownConfig(cfg *IstioConfigDescriptor) (*IstioConfigDescriptor, error) {
+	if cfg.Config == nil {
+		// Kubernetes objects are deleted as they are
+		return cfg, nil
+	}
+
+	schema, found := istioconfig.IstioConfigTypes.GetByType(cfg.Config.Type)
+	if !found {
+		return nil, fmt.Errorf("unknown type %q of config %q", cfg.Config.Type, cfg.Name)
+	}
+
+	spec := proto.Clone(cfg.Config.Spec)
+	spec.Reset()
+	meta := cfg.Config.ConfigMeta
+	// the annotations hold a hash of the spec, which we're replacing
+	meta.Annotations = nil
+	crd := &istioconfig.Config{
+		ConfigMeta: meta,
+		Spec:       spec,
+	}
+
+	yaml, err := protoConfigToYAML(schema, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IstioConfigDescriptor{
+		Name:    cfg.Name,
+		Hosts:   cfg.Hosts,
+		Config:  crd,
+		Yaml:    yaml,
+		Cluster: cfg.Cluster,
+	}, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel/mem"
+	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
+)
+
+// TestTeardownCoversConfigs checks that the teardown of the services in testdata/ deletes every config generated for
+// them, whatever the options they were generated with.
+func TestTeardownCoversConfigs(t *testing.T) {
+	options := map[string]routing.Options{
+		"default":      {},
+		"pinned hosts": {ClusterPinnedHosts: true},
+		"stubs":        {StubServices: true, StubEndpoints: true},
+	}
+	for _, input := range goldenInputs {
+		names, infra := loadClusters(t, filepath.Join("..", "..", "testdata", input.clusters, "clusters.json"))
+		dm := loadServices(t, filepath.Join("..", "..", "testdata", input.services, "services.json"))
+		for desc, opts := range options {
+			t.Run(input.services+"/"+desc, func(t *testing.T) {
+				_, cfgs, err := routing.GenerateConfigs(dm, infra, names, opts)
+				if err != nil {
+					t.Fatalf("GenerateConfigs() failed: %v", err)
+				}
+				// the teardown doesn't depend on the options, other than the DNS suffix
+				_, dels, err := routing.GenerateTeardownConfigs(dm, infra, names, routing.Options{})
+				if err != nil {
+					t.Fatalf("GenerateTeardownConfigs() failed: %v", err)
+				}
+				for svc, clusters := range cfgs {
+					for cluster, configs := range clusters {
+						deleted := make(map[object]bool)
+						for _, del := range dels[svc][cluster] {
+							deleted[objectOf(t, del.Yaml)] = true
+						}
+						for _, cfg := range configs {
+							if o := objectOf(t, cfg.Yaml); !deleted[o] {
+								t.Errorf("teardown of service %q in cluster %q doesn't delete %v", svc, cluster, o)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestTeardownInvalidService checks that a service can be torn down even if its config can't be generated.
+func TestTeardownInvalidService(t *testing.T) {
+	dm := mem.NewDataModel()
+	if err := dm.CreateGlobalService(&datamodel.GlobalService{
+		Name:        "foo",
+		DNSPrefixes: []string{"foo"},
+		Ports:       []datamodel.Port{{Name: "http", ServicePort: 80, Protocol: "HTTP", BackendPort: 8080}},
+		Backends:    map[string]string{"a": "foo.default.svc.cluster.local", "b": "foo.default.svc.cluster.local"},
+		Weights:     map[string]uint32{"a": 50},
+		TLS:         &datamodel.TLS{Mode: datamodel.TLSModeSimple},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	infra := mem.Infrastructure(map[string]datamodel.Cluster{
+		"a": {Name: "a", Address: "a.example.com"},
+		"b": {Name: "b", Address: "b.example.com"},
+	})
+	if _, _, err := routing.GenerateConfigs(dm, infra, []string{"a", "b"}, routing.Options{}); err == nil {
+		t.Fatal("GenerateConfigs() succeeded, want an error for the invalid service")
+	}
+	_, dels, err := routing.GenerateTeardownConfigs(dm, infra, []string{"a", "b"}, routing.Options{})
+	if err != nil {
+		t.Fatalf("GenerateTeardownConfigs() failed: %v", err)
+	}
+	if len(dels["foo"]["a"]) == 0 || len(dels["foo"]["b"]) == 0 {
+		t.Errorf("GenerateTeardownConfigs() = %v, want configs to delete in both clusters", dels)
+	}
+}
+
+// object identifies a Kubernetes object.
+type object struct {
+	kind, namespace, name string
+}
+
+func objectOf(t *testing.T, y []byte) object {
+	var o struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(y, &o); err != nil {
+		t.Fatalf("could not unmarshal config: %v", err)
+	}
+	return object{kind: o.Kind, namespace: o.Metadata.Namespace, name: o.Metadata.Name}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Tetrate, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/istio-ecosystem/coddiwomple/pkg/datamodel"
+
+	istioapi "istio.io/api/networking/v1alpha3"
+	istioconfig "istio.io/istio/pilot/pkg/model"
+)
+
+// Paths of the certificates Citadel mounts into every proxy, including the gateways.
+// Istio 1.0 gateways have no ISTIO_MUTUAL mode, so we configure MUTUAL with these instead; istioMutual switches the
+// config of the clusters running later releases back to ISTIO_MUTUAL.
+const (
+	istioCertChain = "/etc/certs/cert-chain.pem"
+	istioKey       = "/etc/certs/key.pem"
+	istioRootCert  = "/etc/certs/root-cert.pem"
+)
+
+// checkTLS checks that the TLS settings have a known mode, along with the certificates the mode requires.
+func checkTLS(t *datamodel.TLS) error {
+	var missing []string
+	require := func(field, value string) {
+		if value == "" {
+			missing = append(missing, field)
+		}
+	}
+	switch t.Mode {
+	case datamodel.TLSModeSimple:
+		require("server_certificate", t.ServerCertificate)
+		require("private_key", t.PrivateKey)
+	case datamodel.TLSModeMutual:
+		require("server_certificate", t.ServerCertificate)
+		require("private_key", t.PrivateKey)
+		require("ca_certificates", t.CaCertificates)
+		require("client_certificate", t.ClientCertificate)
+		require("client_private_key", t.ClientPrivateKey)
+	case datamodel.TLSModeIstioMutual, datamodel.TLSModePassthrough:
+	default:
+		return fmt.Errorf("unknown TLS mode %q, expected one of %s, %s, %s or %s", t.Mode,
+			datamodel.TLSModeSimple, datamodel.TLSModeMutual, datamodel.TLSModeIstioMutual, datamodel.TLSModePassthrough)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("TLS mode %s requires %s", t.Mode, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// gatewayTLSOptions returns the TLS settings for the ingress gateway servers of a service.
+func gatewayTLSOptions(t *datamodel.TLS) (*istioapi.Server_TLSOptions, error) {
+	if err := checkTLS(t); err != nil {
+		return nil, err
+	}
+	switch t.Mode {
+	case datamodel.TLSModeSimple:
+		return &istioapi.Server_TLSOptions{
+			Mode:              istioapi.Server_TLSOptions_SIMPLE,
+			ServerCertificate: t.ServerCertificate,
+			PrivateKey:        t.PrivateKey,
+		}, nil
+	case datamodel.TLSModeMutual:
+		return &istioapi.Server_TLSOptions{
+			Mode:              istioapi.Server_TLSOptions_MUTUAL,
+			ServerCertificate: t.ServerCertificate,
+			PrivateKey:        t.PrivateKey,
+			CaCertificates:    t.CaCertificates,
+		}, nil
+	case datamodel.TLSModeIstioMutual:
+		return &istioapi.Server_TLSOptions{
+			Mode:              istioapi.Server_TLSOptions_MUTUAL,
+			ServerCertificate: istioCertChain,
+			PrivateKey:        istioKey,
+			CaCertificates:    istioRootCert,
+		}, nil
+	case datamodel.TLSModePassthrough:
+		return &istioapi.Server_TLSOptions{Mode: istioapi.Server_TLSOptions_PASSTHROUGH}, nil
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", t.Mode)
+	}
+}
+
+// clientTLSSettings returns the TLS settings callers use to originate TLS to the ingress gateway.
+// The SNI is set so the gateway can pick the server for the host being called.
+// Returns nil if callers should not originate TLS.
+func clientTLSSettings(t *datamodel.TLS, sni string) (*istioapi.TLSSettings, error) {
+	if err := checkTLS(t); err != nil {
+		return nil, err
+	}
+	switch t.Mode {
+	case datamodel.TLSModeSimple:
+		return &istioapi.TLSSettings{
+			Mode:            istioapi.TLSSettings_SIMPLE,
+			CaCertificates:  t.ClientCaCertificates,
+			SubjectAltNames: t.SubjectAltNames,
+			Sni:             sni,
+		}, nil
+	case datamodel.TLSModeMutual:
+		return &istioapi.TLSSettings{
+			Mode:              istioapi.TLSSettings_MUTUAL,
+			ClientCertificate: t.ClientCertificate,
+			PrivateKey:        t.ClientPrivateKey,
+			CaCertificates:    t.ClientCaCertificates,
+			SubjectAltNames:   t.SubjectAltNames,
+			Sni:               sni,
+		}, nil
+	case datamodel.TLSModeIstioMutual:
+		// Spelled out as MUTUAL so that we can set the SNI the gateway matches on.
+		return &istioapi.TLSSettings{
+			Mode:              istioapi.TLSSettings_MUTUAL,
+			ClientCertificate: istioCertChain,
+			PrivateKey:        istioKey,
+			CaCertificates:    istioRootCert,
+			SubjectAltNames:   t.SubjectAltNames,
+			Sni:               sni,
+		}, nil
+	case datamodel.TLSModePassthrough:
+		// the application originates TLS itself
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", t.Mode)
+	}
+}
+
+// gatewayProtocol is the protocol the ingress gateway server listens with once TLS is applied to the port.
+func gatewayProtocol(protocol string) string {
+	if istioconfig.ParseProtocol(protocol).IsHTTP() {
+		return string(istioconfig.ProtocolHTTPS)
+	}
+	return string(istioconfig.ProtocolTLS)
+}
+
+// passthrough reports whether TLS connections to the port are forwarded untouched to the backend. HTTPS ports
+// always are, since the application has already done TLS end-to-end.
+func passthrough(globalService *datamodel.GlobalService, p datamodel.Port) bool {
+	if istioconfig.ParseProtocol(p.Protocol) == istioconfig.ProtocolHTTPS {
+		return true
+	}
+	return globalService.TLS != nil && globalService.TLS.Mode == datamodel.TLSModePassthrough
+}
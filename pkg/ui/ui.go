@@ -31,12 +31,14 @@ import (
 	"github.com/istio-ecosystem/coddiwomple/pkg/routing"
 )
 
-func RegisterHandlers(dm datamodel.DataModel, infra datamodel.Infrastructure, clusterNames []string, mux *http.ServeMux) {
-	h := handler{dm, infra, clusterNames}
+func RegisterHandlers(dm datamodel.DataModel, infra datamodel.Infrastructure, clusterNames []string, opts routing.Options, mux *http.ServeMux) {
+	h := handler{dm, infra, clusterNames, opts}
 
 	mux.HandleFunc("/", h.serveServiceList)
 	// returns array of configs, each is the content of a <pre> block
-	mux.HandleFunc("/getconfig", h.genConfig)
+	mux.HandleFunc("/getconfig", h.genConfig(h.buildChecked))
+	// as above, but the configs to delete to remove the service
+	mux.HandleFunc("/getteardown", h.genConfig(routing.RemoveGlobalServiceConfigs))
 
 }
 
@@ -44,6 +46,7 @@ type handler struct {
 	dm       datamodel.DataModel
 	infra    datamodel.Infrastructure
 	clusters []string
+	opts     routing.Options
 }
 
 func (h handler) serveServiceList(w http.ResponseWriter, req *http.Request) {
@@ -109,7 +112,7 @@ var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
 					document.querySelectorAll('a').forEach(function(element) {
 						element.addEventListener("click", function() {
 							name = element.getAttribute("data-service-name");
-							XHR("/getconfig", name, function(raw) {
+							XHR(element.getAttribute("data-endpoint"), name, function(raw) {
 								data = JSON.parse(raw)
 								console.log("callback called on element: %s", name)
 								console.log(data)
@@ -136,14 +139,15 @@ var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
 			<tr>
 				<th rowspan="2">Service</th>
 				<th colspan="{{ len .ClusterNames }}">Clusters</th>
-				<th rowspan="2">Generate</th>
+				<th rowspan="2" colspan="2">Generate</th>
 			</tr>
 			<tr>{{ range .ClusterNames }}<th>{{.}}</th>{{ end }}</tr>
 			</tr>{{ range $s := .Services }}
 			<tr>
 				<td>{{ .Name }}</td>{{ range $name := $clusterNames }}
 				{{ if (index $s.Clusters $name) }}<td>X</td>{{ else }}<td />{{ end }}{{ end }}
-				<td><a data-service-name="{{ .Name }}" href="#">Generate Config</a></td>
+				<td><a data-service-name="{{ .Name }}" data-endpoint="/getconfig" href="#">Generate Config</a></td>
+				<td><a data-service-name="{{ .Name }}" data-endpoint="/getteardown" href="#">Teardown Config</a></td>
 			</tr>
 			<tr id="{{ .Name }}-config"></tr>{{end}}
 		</table>
@@ -151,7 +155,25 @@ var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
 </html>
 `))
 
-func (h handler) genConfig(w http.ResponseWriter, req *http.Request) {
+// buildChecked builds the configs of the service like `cw gen`, after checking the services of the DataModel, which
+// change as the clusters do, against each other.
+func (h handler) buildChecked(globalService *datamodel.GlobalService, clusters []string, infra datamodel.Infrastructure, opts routing.Options) (map[string][]*routing.IstioConfigDescriptor, error) {
+	if err := routing.CheckGatewayProtocols(h.dm, infra, clusters); err != nil {
+		return nil, err
+	}
+	for _, err := range routing.CheckImports(h.dm, infra, clusters) {
+		log.Printf("warning: %v", err)
+	}
+	return routing.BuildGlobalServiceConfigs(globalService, clusters, infra, opts)
+}
+
+func (h handler) genConfig(build routing.BuildFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		h.serveConfig(build, w, req)
+	}
+}
+
+func (h handler) serveConfig(build routing.BuildFunc, w http.ResponseWriter, req *http.Request) {
 	svcBytes, err := ioutil.ReadAll(req.Body)
 	defer req.Body.Close()
 
@@ -184,7 +206,13 @@ func (h handler) genConfig(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	perClusterConfig, err := routing.BuildGlobalServiceConfigs(svc, h.clusters, h.infra)
+	perClusterConfig, err := build(svc, h.clusters, h.infra, h.opts)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("failed to generate config for service %s: %v", svcKey, err)
+		fmt.Fprintf(w, "failed to generate config for service %s: %v", svcKey, err)
+		return
+	}
 
 	inOrderOutput := make([]string, len(h.clusters))
 	for i, name := range h.clusters {